@@ -0,0 +1,16 @@
+#!/bin/sh
+# Compiles a Go source file to an executable, linking the runtime.
+#
+#   build.sh prog.go [output]
+
+set -e
+
+src="$1"
+out="${2:-$(basename "$src" .go)}"
+dir="$(dirname "$0")"
+tmp="$(mktemp -d)"
+trap 'rm -rf "$tmp"' EXIT
+
+${GLC:-glc} -o "$tmp/prog.ll" "$src"
+llc -relocation-model=pic -o "$tmp/prog.s" "$tmp/prog.ll"
+${CC:-cc} -rdynamic -o "$out" "$tmp/prog.s" "$dir"/runtime/*.c -lpthread
@@ -13,10 +13,21 @@ type Symbol struct {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "memory":
+			mainMemory()
+			return
+		case "types":
+			mainTypes()
+			return
+		}
+	}
 	ctx := lovm.NewContext(os.Stdout)
 	mod := ctx.NewModule("main")
 	fun := mod.NewFunction("main", lovm.FunctionType(lovm.IntType(32), false, lovm.IntType(32), lovm.IntType(32)))
 	entry := fun.NewBlock()
+	entry.Seal()
 	builder := fun.NewBuilder()
 	builder.SetInsertionPoint(entry)
 
@@ -48,6 +59,7 @@ func mainx() {
 	//	fun := mod.NewFunction("main", lovm.FunctionType(lovm.IntType(32), false, lovm.IntType(32), lovm.PointerType(lovm.PointerType(lovm.IntType(8)))))
 	fun := mod.NewFunction("main", lovm.FunctionType(lovm.IntType(32), false, lovm.IntType(32), lovm.IntType(32)))
 	entry := fun.NewBlock()
+	entry.Seal()
 	builder := fun.NewBuilder()
 	builder.SetInsertionPoint(entry)
 
@@ -80,6 +92,8 @@ func mainx() {
 
 	cnd := builder.IICmp(lovm.IntSGT, op2, lovm.ConstIntFromString(typ, "B", 16))
 	builder.BranchIf(cnd, ifTrue, ifFalse)
+	ifTrue.Seal()
+	ifFalse.Seal()
 	builder.SetInsertionPoint(ifTrue)
 	builder.Assign(varA, builder.IAdd(op1, lovm.ConstInt(typ, 4)))
 	builder.Branch(endIf)
@@ -91,14 +105,69 @@ func mainx() {
 	}
 	builder.Branch(endIf)
 
+	endIf.Seal()
 	builder.SetInsertionPoint(endIf)
 
 	printfType := lovm.FunctionType(lovm.IntType(32), true, lovm.PointerType(lovm.IntType(8)))
 	printfSym := mod.DeclareExternal("printf", printfType)
 	a := builder.Ref(typ, varA)
 	str := mod.ConstString("hello world\n")
-	builder.Call(printfSym.Type(), printfSym.Name(), builder.GEP(str, 0, 0), a)
+	builder.Call(printfSym.Type(), printfSym.Name(), builder.GEP(str, lovm.Indices(0, 0)...), a)
 	builder.Return(a)
 
 	ctx.Emit()
 }
+
+// mainMemory emits aligned and volatile memory accesses.
+func mainMemory() {
+	ctx := lovm.NewContext(os.Stdout)
+	mod := ctx.NewModule("main")
+	typ := lovm.IntType(32)
+	fun := mod.NewFunction("main", lovm.FunctionType(typ, false, typ, typ))
+	entry := fun.NewBlock()
+	entry.Seal()
+	builder := fun.NewBuilder()
+	builder.SetInsertionPoint(entry)
+
+	slot := builder.Alloca(typ, 16)
+	builder.StoreAttrs(fun.Param(0), slot, lovm.MemoryAttrs{Align: 16, Volatile: true})
+	builder.Store(fun.Param(1), builder.Alloca(typ, 0))
+	a := builder.LoadAttrs(slot, lovm.MemoryAttrs{Align: 16, Volatile: true})
+	b := builder.LoadAttrs(slot, lovm.MemoryAttrs{Align: 4})
+	builder.Return(builder.IAdd(a, b))
+
+	ctx.Emit()
+}
+
+// mainTypes emits opaque, packed, recursive and quoted types.
+func mainTypes() {
+	ctx := lovm.NewContext(os.Stdout)
+	mod := ctx.NewModule("main")
+	typ := lovm.IntType(32)
+	handle := mod.NewNamedType("handle")
+	node := mod.NewNamedType("list node")
+	node.SetBody([]lovm.Type{lovm.PointerType(node), typ}, false)
+	packed := lovm.StructType([]lovm.Type{lovm.IntType(8), typ}, true)
+
+	mod.NewGlobal("current", lovm.PointerType(handle), lovm.ValueInitializer{Value: lovm.ConstNull(lovm.PointerType(handle))})
+	header := mod.NewGlobal("header", packed, lovm.ValueInitializer{Value: lovm.ConstStruct(packed, lovm.ConstInt(lovm.IntType(8), 1), lovm.ConstInt(typ, 2))})
+
+	fun := mod.NewFunction("main", lovm.FunctionType(typ, false, typ, typ))
+	entry := fun.NewBlock()
+	entry.Seal()
+	builder := fun.NewBuilder()
+	builder.SetInsertionPoint(entry)
+
+	head := builder.Alloca(node, 0)
+	next := builder.Alloca(node, 0)
+	builder.Store(lovm.ConstNull(lovm.PointerType(node)), builder.GEP(next, lovm.Indices(0, 0)...))
+	builder.Store(fun.Param(0), builder.GEP(next, lovm.Indices(0, 1)...))
+	builder.Store(next, builder.GEP(head, lovm.Indices(0, 0)...))
+	builder.Store(fun.Param(1), builder.GEP(head, lovm.Indices(0, 1)...))
+	second := builder.Load(builder.GEP(head, lovm.Indices(0, 0)...))
+	value := builder.Load(builder.GEP(second, lovm.Indices(0, 1)...))
+	h := builder.Load(builder.GEP(header, lovm.Indices(0, 1)...))
+	builder.Return(builder.IAdd(value, h))
+
+	ctx.Emit()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Arrays are held in registers like structs, except that elements
+// accessed with a variable index go through a copy on the stack,
+// since extractvalue and insertvalue only take constant indices.
+
+// ConstIndex returns the value of a constant index, checking
+// it against the length n of the array if not negative.
+func ConstIndex(c Constant, n int) int {
+	if !IsInteger(c.Type) || !Representable(c.Value, Int) {
+		util.Perrorf("invalid argument: index %s (constant of type %v) must be integer", c.Value, c.Type)
+	}
+	if constant.Sign(c.Value) < 0 {
+		util.Perrorf("invalid argument: index %s (constant of type %v) must not be negative", c.Value, c.Type)
+	}
+	idx, _ := constant.Int64Val(c.Value)
+	if n >= 0 && int(idx) >= n {
+		util.Perrorf("invalid argument: index %d out of bounds [0:%d]", idx, n)
+	}
+	return int(idx)
+}
+
+// Index evaluates the index of an element of an array of length n,
+// or of unknown length if n is negative. It returns the index as an
+// int64, and its value if constant or -1 otherwise.
+func (v *BlockVisitor) Index(e ast.Expr, n int) (lovm.Value, int) {
+	if c, ok := v.ConstValue(e); ok {
+		idx := ConstIndex(c, n)
+		return lovm.ConstInt(Int64.LlvmType(), int64(idx)), idx
+	}
+	ev := v.Evaluate(Int, e)
+	if !IsInteger(ev.Type) {
+		util.Perrorf("invalid argument: index %s (type %v) must be integer", types.ExprString(e), ev.Type)
+	}
+	return v.Extend(ev.Value, ev.Type), -1
+}
+
+// ElementIndices returns the index of each element of an array
+// or slice literal of length n (negative if not known), and the
+// length of the literal. Keys must be constant.
+func (s *Scope) ElementIndices(elts []ast.Expr, n int) ([]int, int) {
+	res := make([]int, len(elts))
+	seen := map[int]bool{}
+	idx, length := 0, 0
+	for i, e := range elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			c, ok := s.ConstValue(kv.Key)
+			if !ok {
+				util.Perrorf("index %s must be integer constant", types.ExprString(kv.Key))
+			}
+			idx = ConstIndex(c, -1)
+		}
+		if n >= 0 && idx >= n {
+			util.Perrorf("index %d out of bounds [0:%d]", idx, n)
+		}
+		if seen[idx] {
+			util.Perrorf("duplicate index %d in array or slice literal", idx)
+		}
+		seen[idx] = true
+		res[i] = idx
+		idx++
+		if idx > length {
+			length = idx
+		}
+	}
+	return res, length
+}
+
+// ArrayLit builds an array value, with omitted
+// elements taking their zero value.
+func (v *BlockVisitor) ArrayLit(typ Type, at ArrayType, elts []ast.Expr) lovm.Value {
+	indices, _ := v.ElementIndices(elts, at.Len)
+	res := ZeroValue(typ)
+	for i, e := range elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			e = kv.Value
+		}
+		ev := v.Evaluate(at.Elem, e)
+		if !ev.AssignableTo(at.Elem) {
+			util.Perrorf("cannot use %v as %v value in array literal", ev.Type, at.Elem)
+		}
+		res = v.Builder.InsertValue(res, ev.Value, indices[i])
+	}
+	return res
+}
+
+// IndexLValue returns the location of the element x[i] of an array,
+// of the array pointed to by x, of a slice, of a string or of a map.
+func (v *BlockVisitor) IndexLValue(n *ast.IndexExpr) LValue {
+	x := v.Addressable(n.X)
+	if st, ok := Underlying(x.Type).(SliceType); ok {
+		return v.SliceIndexLValue(st, x.Load(), n)
+	}
+	if Underlying(x.Type) == String {
+		return v.StringIndexLValue(x.Load(), n)
+	}
+	if mt, ok := Underlying(x.Type).(MapType); ok {
+		return v.MapIndexLValue(mt, x.Load(), n)
+	}
+	if ptr, ok := Underlying(x.Type).(PointerType); ok {
+		if _, ok := Underlying(ptr.Elem).(ArrayType); ok {
+			// p[i] is (*p)[i]
+			p := x.Load()
+			v.NilCheck(p, n.Lbrack)
+			x = v.MemoryLValue(p, ptr.Elem)
+		}
+	}
+	at, ok := Underlying(x.Type).(ArrayType)
+	if !ok {
+		util.Perrorf("invalid operation: cannot index %s (type %v)", types.ExprString(n.X), x.Type)
+	}
+
+	idx, c := v.Index(n.Index, at.Len)
+	if c < 0 {
+		v.BoundsCheck(idx, lovm.ConstInt(Int64.LlvmType(), int64(at.Len)), n.Lbrack)
+	}
+	if x.Address != nil {
+		return v.MemoryLValue(v.Builder.GEP(x.Address, lovm.ConstInt(lovm.IntType(32), 0), idx), at.Elem)
+	}
+	if c >= 0 {
+		return LValue{
+			at.Elem,
+			nil,
+			func() lovm.Value { return v.Builder.ExtractValue(x.Load(), c) },
+			func(value lovm.Value) { x.Store(v.Builder.InsertValue(x.Load(), value, c)) },
+		}
+	}
+	elem := func(tmp lovm.Value) lovm.Value {
+		return v.Builder.GEP(tmp, lovm.ConstInt(lovm.IntType(32), 0), idx)
+	}
+	return LValue{
+		at.Elem,
+		nil,
+		func() lovm.Value { return v.Builder.Load(elem(v.Spill(x.Load()))) },
+		func(value lovm.Value) {
+			tmp := v.Spill(x.Load())
+			v.Builder.Store(value, elem(tmp))
+			x.Store(v.Builder.Load(tmp))
+		},
+	}
+}
+
+// Spill copies a value to a new stack slot and returns its address.
+func (v *BlockVisitor) Spill(value lovm.Value) lovm.Value {
+	tmp := v.Builder.Alloca(value.Type(), 0)
+	v.Builder.Store(value, tmp)
+	return tmp
+}
+
+// BoundsCheck panics at runtime if index is not in [0, length).
+// Negative indices are caught by the unsigned comparison.
+func (v *BlockVisitor) BoundsCheck(index, length lovm.Value, pos token.Pos) {
+	fail := v.Function.NewBlock()
+	ok := v.Function.NewBlock()
+	v.Builder.BranchIf(v.Builder.IICmp(lovm.IntUGE, index, length), fail, ok)
+	fail.Seal()
+	ok.Seal()
+
+	v.Builder.SetInsertionPoint(fail)
+	panicIndex := v.DeclareRuntime("goal_panic_index", lovm.VoidType(), BytePtr, Uintptr, Uintptr)
+	v.Builder.Call(lovm.VoidType(), panicIndex, v.SourcePosition(pos), index, length)
+	v.Builder.Unreachable()
+
+	v.Builder.SetInsertionPoint(ok)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// builtin functions, by name
+var builtins map[string]func(v *ExpressionVisitor, call *ast.CallExpr)
+
+func init() {
+	// initialized here since builtins evaluate
+	// expressions which can call builtins
+	builtins = map[string]func(v *ExpressionVisitor, call *ast.CallExpr){
+		"append":  (*ExpressionVisitor).Append,
+		"cap":     (*ExpressionVisitor).Cap,
+		"close":   (*ExpressionVisitor).Close,
+		"copy":    (*ExpressionVisitor).Copy,
+		"delete":  (*ExpressionVisitor).Delete,
+		"len":     (*ExpressionVisitor).Len,
+		"make":    (*ExpressionVisitor).Make,
+		"new":     (*ExpressionVisitor).New,
+		"panic":   (*ExpressionVisitor).Panic,
+		"recover": (*ExpressionVisitor).Recover,
+	}
+}
+
+// IsBuiltin returns true if the identifier refers to a builtin
+// function which isn't shadowed by a declaration.
+func (s *Scope) IsBuiltin(id *ast.Ident) bool {
+	if _, ok := builtins[id.Name]; !ok {
+		return false
+	}
+	_, shadowed := s.LookupSymbol(id.Name)
+	return !shadowed
+}
+
+// CallBuiltin evaluates a call to a builtin function.
+func (v *ExpressionVisitor) CallBuiltin(id *ast.Ident, call *ast.CallExpr) {
+	if call.Ellipsis.IsValid() && id.Name != "append" {
+		util.Perrorf("invalid use of ... with builtin %s", id.Name)
+	}
+	builtins[id.Name](v, call)
+}
+
+func checkArgs(call *ast.CallExpr, min, max int) {
+	name := call.Fun.(*ast.Ident).Name
+	if len(call.Args) < min {
+		util.Perrorf("not enough arguments for %s", name)
+	}
+	if max >= 0 && len(call.Args) > max {
+		util.Perrorf("too many arguments for %s", name)
+	}
+}
+
+// New evaluates new(T).
+func (v *ExpressionVisitor) New(call *ast.CallExpr) {
+	checkArgs(call, 1, 1)
+	typ := v.ParseType(call.Args[0])
+	v.Value = v.Alloc(typ)
+	v.Type = PointerType{typ}
+}
+
+// Len evaluates len(x).
+func (v *ExpressionVisitor) Len(call *ast.CallExpr) {
+	v.lenOrCap(call, 1)
+}
+
+// Cap evaluates cap(x).
+func (v *ExpressionVisitor) Cap(call *ast.CallExpr) {
+	v.lenOrCap(call, 2)
+}
+
+// lenOrCap evaluates the length or capacity of an array, which are
+// constants, or a slice, whose header holds them at field, or of a
+// string, map or channel.
+func (v *ExpressionVisitor) lenOrCap(call *ast.CallExpr, field int) {
+	checkArgs(call, 1, 1)
+	x := v.BlockVisitor.Evaluate(Any, call.Args[0])
+	typ := Underlying(x.Type)
+	if ptr, ok := typ.(PointerType); ok {
+		// len(p) is len(*p) for pointers to arrays
+		typ = Underlying(ptr.Elem)
+	}
+	switch t := typ.(type) {
+	case ArrayType:
+		v.Value = lovm.ConstInt(Int.LlvmType(), int64(t.Len))
+	case SliceType:
+		v.Value = v.Builder.ExtractValue(x.Value, field)
+	case PrimitiveType:
+		if t != String || field != 1 {
+			util.Perrorf("invalid argument: %s (type %v) for %s", types.ExprString(call.Args[0]), x.Type, call.Fun)
+		}
+		v.Value = v.Builder.ExtractValue(x.Value, 1)
+	case MapType:
+		if field != 1 {
+			util.Perrorf("invalid argument: %s (type %v) for %s", types.ExprString(call.Args[0]), x.Type, call.Fun)
+		}
+		v.Value = v.MapLen(x.Value)
+	case ChanType:
+		v.Value = v.ChanLen(x.Value, field)
+	default:
+		util.Perrorf("invalid argument: %s (type %v) for %s", types.ExprString(call.Args[0]), x.Type, call.Fun)
+	}
+	v.Type = Int
+}
+
+// Make evaluates make(T, args...).
+func (v *ExpressionVisitor) Make(call *ast.CallExpr) {
+	checkArgs(call, 1, -1)
+	typ := v.ParseType(call.Args[0])
+	switch t := Underlying(typ).(type) {
+	case SliceType:
+		checkArgs(call, 2, 3)
+		length := v.Size(call.Args[1])
+		capacity := length
+		if len(call.Args) > 2 {
+			capacity = v.Size(call.Args[2])
+		}
+		v.Value = v.MakeSlice(t, length, capacity)
+	case MapType:
+		checkArgs(call, 1, 2)
+		hint := lovm.Value(lovm.ConstInt(Uintptr, 0))
+		if len(call.Args) > 1 {
+			hint = v.Size(call.Args[1])
+		}
+		v.Value = v.MakeMap(t, hint)
+	case ChanType:
+		checkArgs(call, 1, 2)
+		size := lovm.Value(lovm.ConstInt(Uintptr, 0))
+		if len(call.Args) > 1 {
+			size = v.Size(call.Args[1])
+		}
+		v.Value = v.MakeChan(t, size)
+	default:
+		util.Perrorf("invalid argument: cannot make %v", typ)
+	}
+	v.Type = typ
+}
+
+// Append evaluates append(s, elems...) and append(s, t...).
+func (v *ExpressionVisitor) Append(call *ast.CallExpr) {
+	checkArgs(call, 1, -1)
+	s := v.BlockVisitor.Evaluate(v.Type, call.Args[0])
+	st, ok := Underlying(s.Type).(SliceType)
+	if !ok {
+		util.Perrorf("invalid argument: %s (type %v) is not a slice", types.ExprString(call.Args[0]), s.Type)
+	}
+	if call.Ellipsis.IsValid() {
+		checkArgs(call, 2, 2)
+		t := v.BlockVisitor.Evaluate(s.Type, call.Args[1])
+		if _, ok := Underlying(t.Type).(SliceType); !ok || !Identical(Underlying(t.Type), Underlying(s.Type)) {
+			util.Perrorf("cannot use %s (type %v) as %v value in append", types.ExprString(call.Args[1]), t.Type, s.Type)
+		}
+		v.Value = v.AppendSlice(st, s.Value, t.Value)
+	} else {
+		var elems []lovm.Value
+		for _, e := range call.Args[1:] {
+			ev := v.BlockVisitor.Evaluate(st.Elem, e)
+			if !ev.AssignableTo(st.Elem) {
+				util.Perrorf("cannot use %v as %v value in append", ev.Type, st.Elem)
+			}
+			elems = append(elems, ev.Value)
+		}
+		v.Value = v.AppendValues(st, s.Value, elems)
+	}
+	v.Type = s.Type
+}
+
+// Copy evaluates copy(dst, src).
+func (v *ExpressionVisitor) Copy(call *ast.CallExpr) {
+	checkArgs(call, 2, 2)
+	dst := v.BlockVisitor.Evaluate(Any, call.Args[0])
+	src := v.BlockVisitor.Evaluate(dst.Type, call.Args[1])
+	dt, ok := Underlying(dst.Type).(SliceType)
+	if !ok || !Identical(dt, Underlying(src.Type)) {
+		util.Perrorf("invalid argument: copy expects slice arguments of identical element types; found %v and %v", dst.Type, src.Type)
+	}
+	v.Value = v.CopySlice(dt, dst.Value, src.Value)
+	v.Type = Int
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Channels are lowered to pointers to channels allocated by the
+// runtime, null for nil channels. Elements are passed to the runtime
+// by address. Goroutines are threads running a function taking an
+// environment, holding the function value and the arguments evaluated
+// by the go statement, as deferred calls do, see runtime/proc.c.
+
+// MakeChan allocates a channel with a buffer of size elements.
+func (v *BlockVisitor) MakeChan(ct ChanType, size lovm.Value) lovm.Value {
+	makechan := v.DeclareRuntime("goal_makechan", BytePtr, Uintptr, Uintptr)
+	return v.Builder.Call(BytePtr, makechan, lovm.ConstInt(Uintptr, int64(Sizeof(ct.Elem))), size)
+}
+
+// ChanOperand evaluates the channel operand of a send or receive
+// operation, which must allow the direction dir.
+func (v *BlockVisitor) ChanOperand(e ast.Expr, dir ast.ChanDir, op string) (*ExpressionVisitor, ChanType) {
+	c := v.Evaluate(Any, e)
+	ct, ok := Underlying(c.Type).(ChanType)
+	if !ok {
+		util.Perrorf("invalid operation: cannot %s non-channel %s (type %v)", op, types.ExprString(e), c.Type)
+	}
+	if ct.Dir&dir == 0 {
+		if dir == ast.SEND {
+			util.Perrorf("invalid operation: cannot send to receive-only channel %s (type %v)", types.ExprString(e), c.Type)
+		}
+		util.Perrorf("invalid operation: cannot receive from send-only channel %s (type %v)", types.ExprString(e), c.Type)
+	}
+	return c, ct
+}
+
+// Send compiles a send statement, which blocks until
+// the value is received or buffered.
+func (v *BlockVisitor) Send(n *ast.SendStmt) {
+	c, ct := v.ChanOperand(n.Chan, ast.SEND, "send to")
+	x := v.Evaluate(ct.Elem, n.Value)
+	if !x.AssignableTo(ct.Elem) {
+		util.Perrorf("cannot use %s (type %v) as type %v in send", types.ExprString(n.Value), x.Type, ct.Elem)
+	}
+	chansend := v.DeclareRuntime("goal_chansend", lovm.VoidType(), BytePtr, BytePtr)
+	v.Builder.Call(lovm.VoidType(), chansend, c.Value, v.Temporary(x.Value))
+}
+
+// ChanRecv receives a value from a channel, returning the value, or the
+// zero value if the channel is closed, and whether a value was received.
+func (v *BlockVisitor) ChanRecv(ct ChanType, c lovm.Value) (value, ok lovm.Value) {
+	chanrecv := v.DeclareRuntime("goal_chanrecv", Uintptr, BytePtr, BytePtr)
+	slot := v.Spill(ZeroValue(ct.Elem))
+	received := v.Builder.Call(Uintptr, chanrecv, c, v.Builder.BitCast(slot, BytePtr))
+	return v.Builder.Load(slot), v.Builder.IICmp(lovm.IntNE, received, lovm.ConstInt(Uintptr, 0))
+}
+
+// Receive evaluates the receive operation <-ch.
+func (v *ExpressionVisitor) Receive(e ast.Expr) {
+	c, ct := v.ChanOperand(e, ast.RECV, "receive from")
+	v.Value, _ = v.ChanRecv(ct, c.Value)
+	v.Type = ct.Elem
+}
+
+// ReceiveOk evaluates <-ch in the comma-ok form, returning the
+// value and whether it was sent rather than due to a close.
+func (v *BlockVisitor) ReceiveOk(n *ast.UnaryExpr) []*ExpressionVisitor {
+	c, ct := v.ChanOperand(n.X, ast.RECV, "receive from")
+	value, ok := v.ChanRecv(ct, c.Value)
+	return []*ExpressionVisitor{{v, value, ct.Elem}, {v, ok, Bool}}
+}
+
+// ChanLen returns the number of elements buffered in a channel,
+// or its capacity if field is 2, as an int.
+func (v *BlockVisitor) ChanLen(c lovm.Value, field int) lovm.Value {
+	name := "goal_chanlen"
+	if field == 2 {
+		name = "goal_chancap"
+	}
+	fn := v.DeclareRuntime(name, Uintptr, BytePtr)
+	return v.Builder.Trunc(v.Builder.Call(Uintptr, fn, c), Int.LlvmType())
+}
+
+// Close evaluates close(ch).
+func (v *ExpressionVisitor) Close(call *ast.CallExpr) {
+	checkArgs(call, 1, 1)
+	c := v.BlockVisitor.Evaluate(Any, call.Args[0])
+	ct, ok := Underlying(c.Type).(ChanType)
+	if !ok {
+		util.Perrorf("invalid argument: %s (type %v) is not a channel", types.ExprString(call.Args[0]), c.Type)
+	}
+	if ct.Dir == ast.RECV {
+		util.Perrorf("invalid operation: cannot close receive-only channel %s (type %v)", types.ExprString(call.Args[0]), c.Type)
+	}
+	closechan := v.DeclareRuntime("goal_closechan", lovm.VoidType(), BytePtr)
+	v.Builder.Call(lovm.VoidType(), closechan, c.Value)
+	v.Type = Any
+}
+
+// ChanIterator returns the iteration over the values
+// received from a channel until it is closed.
+func (v *BlockVisitor) ChanIterator(ct ChanType, c *ExpressionVisitor, e ast.Expr) RangeIterator {
+	if ct.Dir == ast.SEND {
+		util.Perrorf("invalid operation: range %s receive from send-only channel (type %v)", types.ExprString(e), c.Type)
+	}
+	chanrecv := v.DeclareRuntime("goal_chanrecv", Uintptr, BytePtr, BytePtr)
+	slot := v.Builder.Alloca(ct.Elem.LlvmType(), 0)
+	return RangeIterator{
+		ct.Elem,
+		nil,
+		func() lovm.Value {
+			received := v.Builder.Call(Uintptr, chanrecv, c.Value, v.Builder.BitCast(slot, BytePtr))
+			return v.Builder.IICmp(lovm.IntNE, received, lovm.ConstInt(Uintptr, 0))
+		},
+		func() (lovm.Value, lovm.Value) { return v.Builder.Load(slot), nil },
+		func() {},
+	}
+}
+
+// A SelectCase is a send or receive clause of a select statement.
+type SelectCase struct {
+	Clause *ast.CommClause
+	Elem   Type
+	// the value sent or receiving the value, in memory
+	Slot lovm.Value
+	// the variables assigned by a receive, if any, and
+	// whether they are declared or assigned
+	Lhs []ast.Expr
+	Tok token.Token
+}
+
+// SelectCase evaluates the channel operand and the value sent, if any,
+// of a clause of a select statement, returning the case and the
+// runtime descriptor of the operation.
+func (v *BlockVisitor) SelectCase(cc *ast.CommClause) (SelectCase, []lovm.Value) {
+	if s, ok := cc.Comm.(*ast.SendStmt); ok {
+		c, ct := v.ChanOperand(s.Chan, ast.SEND, "send to")
+		x := v.Evaluate(ct.Elem, s.Value)
+		if !x.AssignableTo(ct.Elem) {
+			util.Perrorf("cannot use %s (type %v) as type %v in send", types.ExprString(s.Value), x.Type, ct.Elem)
+		}
+		slot := v.Temporary(x.Value)
+		return SelectCase{cc, ct.Elem, slot, nil, token.ILLEGAL},
+			[]lovm.Value{c.Value, slot, lovm.ConstInt(Uintptr, int64(ast.SEND))}
+	}
+
+	var recv ast.Expr
+	sc := SelectCase{Clause: cc}
+	switch s := cc.Comm.(type) {
+	case *ast.ExprStmt:
+		recv = s.X
+	case *ast.AssignStmt:
+		if len(s.Lhs) <= 2 && len(s.Rhs) == 1 {
+			recv, sc.Lhs, sc.Tok = s.Rhs[0], s.Lhs, s.Tok
+		}
+	}
+	u, ok := ast.Unparen(recv).(*ast.UnaryExpr)
+	if !ok || u.Op != token.ARROW {
+		util.Perrorf("select case must be receive, send or assign recv")
+	}
+	c, ct := v.ChanOperand(u.X, ast.RECV, "receive from")
+	sc.Elem = ct.Elem
+	sc.Slot = v.Builder.BitCast(v.Spill(ZeroValue(ct.Elem)), BytePtr)
+	return sc, []lovm.Value{c.Value, sc.Slot, lovm.ConstInt(Uintptr, int64(ast.RECV))}
+}
+
+// CompileSelect compiles a select statement. The channel operands and
+// the values sent are evaluated in source order, and the runtime picks
+// one of the cases ready to proceed at random, blocking until one is
+// ready unless there is a default clause. The variables of a receive
+// are assigned in the clause picked.
+func (v *BlockVisitor) CompileSelect(n *ast.SelectStmt, label string) {
+	caseType := lovm.StructType([]lovm.Type{BytePtr, BytePtr, Uintptr}, false)
+	var cases []SelectCase
+	var descs [][]lovm.Value
+	var def *ast.CommClause
+	for _, s := range n.Body.List {
+		cc := s.(*ast.CommClause)
+		if cc.Comm == nil {
+			if def != nil {
+				util.Perrorf("multiple defaults in select")
+			}
+			def = cc
+			continue
+		}
+		sc, desc := v.SelectCase(cc)
+		cases = append(cases, sc)
+		descs = append(descs, desc)
+	}
+
+	array := v.Builder.Alloca(lovm.ArrayType(caseType, len(cases)), 0)
+	for i, desc := range descs {
+		for j, field := range desc {
+			v.Builder.Store(field, v.Builder.GEP(array, lovm.Indices(0, i, j)...))
+		}
+	}
+	recvok := v.Builder.Alloca(Uintptr, 0)
+	blocking := int64(1)
+	if def != nil {
+		blocking = 0
+	}
+	selectgo := v.DeclareRuntime("goal_select", Uintptr, BytePtr, Uintptr, Uintptr, lovm.PointerType(Uintptr))
+	index := v.Builder.Call(Uintptr, selectgo, v.Builder.BitCast(array, BytePtr),
+		lovm.ConstInt(Uintptr, int64(len(cases))), lovm.ConstInt(Uintptr, blocking), recvok)
+
+	exit := v.Function.NewBlock()
+	v.PushTarget(BranchTarget{label, exit, nil})
+	for i, sc := range cases {
+		body := v.Function.NewBlock()
+		next := v.Function.NewBlock()
+		v.Builder.BranchIf(v.Builder.IICmp(lovm.IntEQ, index, lovm.ConstInt(Uintptr, int64(i))), body, next)
+		body.Seal()
+		next.Seal()
+
+		v.Builder.SetInsertionPoint(body)
+		cv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+		if len(sc.Lhs) > 0 {
+			value := v.Builder.Load(v.Builder.BitCast(sc.Slot, lovm.PointerType(sc.Elem.LlvmType())))
+			ok := v.Builder.IICmp(lovm.IntNE, v.Builder.Load(recvok), lovm.ConstInt(Uintptr, 0))
+			cv.AssignReceived(sc, []*ExpressionVisitor{{cv, value, sc.Elem}, {cv, ok, Bool}})
+		}
+		for _, s := range sc.Clause.Body {
+			Walk(cv, s)
+		}
+		v.Builder.Branch(exit)
+		v.Builder.SetInsertionPoint(next)
+	}
+	if def != nil {
+		cv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+		for _, s := range def.Body {
+			Walk(cv, s)
+		}
+	}
+	v.Builder.Branch(exit)
+	v.PopTarget()
+	exit.Seal()
+	v.Builder.SetInsertionPoint(exit)
+}
+
+// AssignReceived declares or assigns the variables of
+// the receive of a select case to the received values.
+func (v *BlockVisitor) AssignReceived(sc SelectCase, values []*ExpressionVisitor) {
+	for i, e := range sc.Lhs {
+		ev := values[i]
+		if sc.Tok == token.DEFINE {
+			id, ok := e.(*ast.Ident)
+			if !ok {
+				util.Perrorf("non-name %s on left side of :=", types.ExprString(e))
+			}
+			if id.Name != "_" {
+				v.DeclareVar(id, ev.Type, ev.Value)
+			}
+			continue
+		}
+		lv := v.Addressable(e)
+		if lv.Type != Any && !ev.AssignableTo(lv.Type) {
+			util.Perrorf("cannot use %v as %v value in assignment", ev.Type, lv.Type)
+		}
+		lv.Store(ev.Value)
+	}
+}
+
+// Go compiles a go statement, starting a goroutine making the call.
+func (v *BlockVisitor) Go(call *ast.CallExpr) {
+	fun, env := v.SaveCall(call, "go")
+	goproc := v.DeclareRuntime("goal_go", lovm.VoidType(), BytePtr, BytePtr)
+	v.Builder.Call(lovm.VoidType(), goproc, fun, env)
+}
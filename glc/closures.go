@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Function values are { fnptr, env } closures. The function takes
+// the environment as its first parameter, before the parameters of
+// the function type. Function literals are lifted to functions named
+// after the enclosing function, as in main.main.func1, whose
+// environment holds the addresses of the captured variables, which
+// live on the heap. Declared functions and method expressions are
+// called through adapters ignoring the environment, while method
+// values are closures over the receiver, as in interface values.
+
+// CapturedBy returns true if id refers to a variable
+// declared outside of the function literal lit.
+func CapturedBy(id *ast.Ident, lit *ast.FuncLit) bool {
+	obj := id.Obj
+	return obj != nil && obj.Kind == ast.Var && (obj.Pos() < lit.Pos() || obj.Pos() >= lit.End())
+}
+
+// LocalSymbol looks up a symbol declared in the enclosing
+// functions, ignoring the package and universe scopes.
+func (v *BlockVisitor) LocalSymbol(name string) (Symbol, bool) {
+	for scope := &v.Scope; scope != nil && scope != &v.ModuleVisitor.Scope; scope = scope.Parent {
+		if sym, ok := scope.Symbols[name]; ok {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Captured returns the variables of the enclosing
+// functions referred to by the function literal n.
+func (v *BlockVisitor) Captured(n *ast.FuncLit) (res []Symbol) {
+	seen := map[*ast.Object]bool{}
+	ast.Inspect(n.Body, func(node ast.Node) bool {
+		id, ok := node.(*ast.Ident)
+		if !ok || !CapturedBy(id, n) || seen[id.Obj] {
+			return true
+		}
+		seen[id.Obj] = true
+		sym, ok := v.LocalSymbol(id.Name)
+		if !ok {
+			// package level variable
+			return true
+		}
+		if sym.Address == nil {
+			util.Perrorf("internal error: captured variable %s is not in memory", id.Name)
+		}
+		res = append(res, sym)
+		return true
+	})
+	return res
+}
+
+// ClosureType returns the type of the llvm functions taking
+// a closure environment and the parameters of ft.
+func ClosureType(ft FunctionType) *lovm.FuncType {
+	return FunctionType{append([]Symbol{{Type: PointerType{Uint8}}}, ft.Params...), ft.Results}.FuncType()
+}
+
+// Closure returns a function value of type ft calling
+// fun, an i8*, with the environment env.
+func (v *BlockVisitor) Closure(ft FunctionType, fun, env lovm.Value) lovm.Value {
+	res := v.Builder.InsertValue(lovm.Undef(ft.LlvmType()), fun, 0)
+	return v.Builder.InsertValue(res, env, 1)
+}
+
+// Adapter returns the address of a function taking a closure
+// environment, which it ignores, and the parameters of ft, and calling
+// the function name. If deref is set, the first parameter is a pointer
+// to the first argument of the call.
+func (v *ModuleVisitor) Adapter(name string, ft FunctionType, deref bool) lovm.Value {
+	key := name + ".f"
+	if deref {
+		key = name + ".fp"
+	}
+	if adapter, ok := v.Adapters[key]; ok {
+		return adapter
+	}
+	llvmType := ClosureType(ft)
+	fun := v.Module.NewFunction(key, llvmType)
+	builder := fun.NewBuilder()
+	entry := fun.NewBlock()
+	entry.Seal()
+	builder.SetInsertionPoint(entry)
+
+	var args []lovm.Value
+	for i := range ft.Params {
+		args = append(args, fun.Param(i+1))
+	}
+	if deref {
+		args[0] = builder.Load(args[0])
+	}
+	res := builder.Call(llvmType.ReturnType, name, args...)
+	if len(ft.Results) == 0 {
+		builder.Return(nil)
+	} else {
+		builder.Return(res)
+	}
+
+	adapter := lovm.ConstBitCast(fun.Ref(), BytePtr)
+	v.Adapters[key] = adapter
+	return adapter
+}
+
+// FuncValue returns the function value of the declared function sym.
+func (v *BlockVisitor) FuncValue(sym Symbol) lovm.Value {
+	ft := sym.Type.(FunctionType)
+	return v.Closure(ft, v.Adapter(sym.Link, ft, false), lovm.ConstNull(BytePtr))
+}
+
+// MethodValue evaluates x.name, where name is the method m of x or a
+// method of the interface x, to a closure calling it on a copy of x,
+// or on the address of x for methods with pointer receivers.
+func (v *ExpressionVisitor) MethodValue(x LValue, name *ast.Ident, m *Method) {
+	if it, ok := Underlying(x.Type).(*InterfaceType); ok {
+		i := it.MethodIndex(name.Name)
+		if i < 0 {
+			util.Perrorf("%v has no field or method %s", x.Type, name.Name)
+		}
+		iface := x.Load()
+		ft := it.Methods[i].Type
+		v.Value, v.Type = v.Closure(ft, v.ItabFunc(iface, i), v.Builder.ExtractValue(iface, 1)), ft
+		return
+	}
+	recv := v.Receiver(x, m, name.Pos())
+	v.Value = v.Closure(m.Type, v.MethodWrapper(m, recv.Type), v.Box(recv.Value, recv.Type))
+	v.Type = m.Type
+}
+
+// MethodExpr evaluates the method expression T.M to a
+// function taking the receiver as its first parameter.
+func (v *ExpressionVisitor) MethodExpr(n *ast.SelectorExpr, typ Type) {
+	m := ExprMethod(n, typ)
+	ft := FunctionType{append([]Symbol{{Type: typ}}, m.Type.Params...), m.Type.Results}
+	_, pointer := typ.(PointerType)
+	v.Value = v.Closure(ft, v.Adapter(m.Symbol, ft, pointer && !m.PointerRecv), lovm.ConstNull(BytePtr))
+	v.Type = ft
+}
+
+// FuncLit evaluates a function literal, lifting its body to
+// a new function, to a closure over the captured variables.
+func (v *ExpressionVisitor) FuncLit(n *ast.FuncLit) {
+	ft := v.ParseFuncType(n.Type)
+	captured := v.Captured(n)
+	var fields []Field
+	for _, sym := range captured {
+		fields = append(fields, Field{Name: sym.Name, Type: PointerType{sym.Type}})
+	}
+	env := StructType{fields}
+
+	v.Literals++
+	fun := v.Module.NewFunction(fmt.Sprintf("%s.func%d", v.Function.Name, v.Literals), ClosureType(ft))
+	bv := &BlockVisitor{NewScope(&v.Scope), v.NewFunctionVisitor(ft, fun, AddressTaken(n.Body, v.PointerMethods))}
+	if len(captured) > 0 {
+		ptr := bv.Builder.BitCast(fun.Param(0), lovm.PointerType(env.LlvmType()))
+		for i, sym := range captured {
+			sym.Address = bv.Builder.Load(bv.Builder.GEP(ptr, lovm.Indices(0, i)...))
+			bv.AddVar(sym)
+		}
+	}
+	bv.CompileBody(FieldIdents(n.Type.Params), FieldIdents(n.Type.Results), 1, n.Body)
+
+	var data lovm.Value = lovm.ConstNull(BytePtr)
+	if len(captured) > 0 {
+		ptr := v.Alloc(env)
+		for i, sym := range captured {
+			v.Builder.Store(sym.Address, v.Builder.GEP(ptr, lovm.Indices(0, i)...))
+		}
+		data = v.Builder.BitCast(ptr, BytePtr)
+	}
+	v.Value = v.Closure(ft, lovm.ConstBitCast(fun.Ref(), BytePtr), data)
+	v.Type = ft
+}
+
+// CallClosure evaluates the call of the function value fn.
+func (v *ExpressionVisitor) CallClosure(fn *ExpressionVisitor, name string, args []ast.Expr) {
+	ft, ok := Underlying(fn.Type).(FunctionType)
+	if !ok {
+		util.Perrorf("cannot call non-function %s (type %v)", name, fn.Type)
+	}
+	fun := v.Builder.BitCast(v.Builder.ExtractValue(fn.Value, 0), lovm.PointerType(ClosureType(ft)))
+	env := &ExpressionVisitor{v.BlockVisitor, v.Builder.ExtractValue(fn.Value, 1), PointerType{Uint8}}
+	v.CallFunction(name, fun, ft, env, args)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Untyped constants have an untyped type until they are
+// used in a context requiring a typed value.
+type UntypedType struct {
+	Name    string
+	Default Type
+}
+
+var (
+	UntypedInt    = UntypedType{"untyped int", Int}
+	UntypedRune   = UntypedType{"untyped rune", Int32}
+	UntypedBool   = UntypedType{"untyped bool", Bool}
+	UntypedString = UntypedType{"untyped string", String}
+)
+
+func (u UntypedType) LlvmType() lovm.Type {
+	return u.Default.LlvmType()
+}
+
+func (u UntypedType) String() string {
+	return fmt.Sprintf("Type(%s)", u.Name)
+}
+
+// A Constant is the value of a constant expression, computed
+// at compile time with arbitrary precision.
+type Constant struct {
+	Value constant.Value
+	Type  Type
+}
+
+func IsUntyped(t Type) bool {
+	_, ok := t.(UntypedType)
+	return ok
+}
+
+func IsInteger(t Type) bool {
+	if p, ok := Underlying(t).(PrimitiveType); ok {
+		return p != Bool && p != String
+	}
+	return t == UntypedInt || t == UntypedRune
+}
+
+// Representable returns true if the constant value
+// can be represented by a value of type t.
+func Representable(c constant.Value, t Type) bool {
+	switch {
+	case IsInteger(t):
+		if c.Kind() != constant.Int {
+			return false
+		}
+		if IsUntyped(t) {
+			return true
+		}
+		bits := IntegerBits(t)
+		var min, max constant.Value
+		if Underlying(t).(PrimitiveType).Signed {
+			min = constant.Shift(constant.MakeInt64(-1), token.SHL, uint(bits-1))
+			max = constant.BinaryOp(constant.Shift(constant.MakeInt64(1), token.SHL, uint(bits-1)), token.SUB, constant.MakeInt64(1))
+		} else {
+			min = constant.MakeInt64(0)
+			max = constant.BinaryOp(constant.Shift(constant.MakeInt64(1), token.SHL, uint(bits)), token.SUB, constant.MakeInt64(1))
+		}
+		return constant.Compare(c, token.GEQ, min) && constant.Compare(c, token.LEQ, max)
+	case Underlying(t) == Bool || t == UntypedBool:
+		return c.Kind() == constant.Bool
+	case Underlying(t) == String || t == UntypedString:
+		return c.Kind() == constant.String
+	}
+	return false
+}
+
+// UntypedOf returns the untyped type of the constants
+// which can be converted to t.
+func UntypedOf(t Type) Type {
+	switch {
+	case IsInteger(t):
+		return UntypedInt
+	case Underlying(t) == Bool:
+		return UntypedBool
+	case Underlying(t) == String:
+		return UntypedString
+	}
+	return t
+}
+
+// IntegerBits returns the size in bits of an integer type.
+func IntegerBits(t Type) int {
+	var bits int
+	fmt.Sscanf(t.LlvmType().Name(), "i%d", &bits)
+	return bits
+}
+
+// Convert returns the constant converted to type t,
+// reporting an error if it doesn't fit.
+func (c Constant) Convert(t Type) Constant {
+	if !Representable(c.Value, t) {
+		if c.Value.Kind() == constant.Int && IsInteger(t) {
+			util.Perrorf("constant %s overflows %v", c.Value, t)
+		}
+		util.Perrorf("cannot use constant %s (%v) as %v value", c.Value, c.Type, t)
+	}
+	return Constant{c.Value, t}
+}
+
+// LookupSymbol is like ResolveSymbol but doesn't
+// fail if the symbol is not defined.
+func (s *Scope) LookupSymbol(name string) (Symbol, bool) {
+	for scope := s; scope != nil; scope = scope.Parent {
+		if res, ok := scope.Symbols[name]; ok {
+			return res, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// ConstValue evaluates a constant expression. It returns
+// false if the expression is not constant.
+func (s *Scope) ConstValue(e ast.Expr) (Constant, bool) {
+	switch n := e.(type) {
+	case *ast.BasicLit:
+		switch n.Kind {
+		case token.INT:
+			return Constant{constant.MakeFromLiteral(n.Value, n.Kind, 0), UntypedInt}, true
+		case token.STRING:
+			return Constant{constant.MakeFromLiteral(n.Value, n.Kind, 0), UntypedString}, true
+		case token.CHAR:
+			return Constant{constant.MakeFromLiteral(n.Value, n.Kind, 0), UntypedRune}, true
+		}
+	case *ast.Ident:
+		sym, ok := s.LookupSymbol(n.Name)
+		if ok && sym.Const != nil {
+			return Constant{sym.Const, sym.Type}, true
+		}
+		if !ok && (n.Name == "true" || n.Name == "false") {
+			return Constant{constant.MakeBool(n.Name == "true"), UntypedBool}, true
+		}
+	case *ast.ParenExpr:
+		return s.ConstValue(n.X)
+	case *ast.UnaryExpr:
+		x, ok := s.ConstValue(n.X)
+		if !ok {
+			return x, false
+		}
+		var prec uint
+		if IsInteger(x.Type) && !IsUntyped(x.Type) && !Underlying(x.Type).(PrimitiveType).Signed {
+			prec = uint(IntegerBits(x.Type))
+		}
+		return Constant{constant.UnaryOp(n.Op, x.Value, prec), x.Type}.Convert(x.Type), true
+	case *ast.BinaryExpr:
+		x, ok := s.ConstValue(n.X)
+		if !ok {
+			return x, false
+		}
+		y, ok := s.ConstValue(n.Y)
+		if !ok {
+			return y, false
+		}
+		return x.BinaryOp(n.Op, y), true
+	case *ast.CallExpr:
+		// conversion of a constant
+		if len(n.Args) != 1 {
+			return Constant{}, false
+		}
+		x, ok := s.ConstValue(n.Args[0])
+		if !ok {
+			return x, false
+		}
+		if id, ok := n.Fun.(*ast.Ident); ok && id.Name == "len" && x.Value.Kind() == constant.String {
+			if _, shadowed := s.LookupSymbol(id.Name); !shadowed {
+				return Constant{constant.MakeInt64(int64(len(constant.StringVal(x.Value)))), Int}, true
+			}
+		}
+		typ, err := s.ResolveType(n.Fun)
+		if err != nil || UntypedOf(typ) == typ {
+			// not a conversion to a basic type
+			return Constant{}, false
+		}
+		return x.Convert(typ), true
+	}
+	return Constant{}, false
+}
+
+// BinaryOp computes x op y, following the
+// rules for mixing typed and untyped constants.
+func (x Constant) BinaryOp(op token.Token, y Constant) Constant {
+	switch op {
+	case token.SHL, token.SHR:
+		if !Representable(y.Value, Uint) {
+			util.Perrorf("invalid shift count %s", y.Value)
+		}
+		s, _ := constant.Uint64Val(y.Value)
+		return Constant{constant.Shift(x.Value, op, uint(s)), x.Type}.Convert(x.Type)
+	}
+
+	typ := x.Type
+	if IsUntyped(x.Type) && !IsUntyped(y.Type) {
+		typ = y.Type
+	} else if x.Type == UntypedInt && y.Type == UntypedRune {
+		// untyped runes take precedence over untyped ints
+		typ = y.Type
+	} else if !IsUntyped(x.Type) && !IsUntyped(y.Type) && !Identical(x.Type, y.Type) {
+		util.Perrorf("mismatched types %v and %v", x.Type, y.Type)
+	}
+	x, y = x.Convert(typ), y.Convert(typ)
+
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return Constant{constant.MakeBool(constant.Compare(x.Value, op, y.Value)), UntypedBool}
+	case token.QUO, token.REM:
+		if constant.Sign(y.Value) == 0 {
+			util.Perrorf("division by zero")
+		}
+		if op == token.QUO && IsInteger(typ) {
+			// integer division
+			op = token.QUO_ASSIGN
+		}
+	}
+	return Constant{constant.BinaryOp(x.Value, op, y.Value), typ}.Convert(typ)
+}
+
+// Emit returns the llvm value of the constant. Untyped
+// constants take the type hint if compatible, or their
+// default type otherwise.
+func (c Constant) Emit(mod *lovm.Module, hint Type) (lovm.Value, Type) {
+	typ := c.Type
+	if u, ok := typ.(UntypedType); ok {
+		typ = u.Default
+		if hint != nil && hint != Any && !IsUntyped(hint) && Representable(c.Value, UntypedOf(hint)) {
+			typ = hint
+		}
+	}
+	c = c.Convert(typ)
+
+	switch c.Value.Kind() {
+	case constant.Int:
+		// wraps around for unsigned values not fitting an int64
+		val, ok := constant.Int64Val(c.Value)
+		if !ok {
+			u, _ := constant.Uint64Val(c.Value)
+			val = int64(u)
+		}
+		return lovm.ConstInt(typ.LlvmType(), val), typ
+	case constant.Bool:
+		if constant.BoolVal(c.Value) {
+			return lovm.ConstInt(typ.LlvmType(), 1), typ
+		}
+		return lovm.ConstInt(typ.LlvmType(), 0), typ
+	case constant.String:
+		return ConstStringHeader(mod, typ, constant.StringVal(c.Value)), typ
+	}
+	util.Perrorf("unimplemented constant %s", c.Value)
+	return nil, nil
+}
+
+// A ConstSpec is a const declaration spec, with the
+// implicit repetition of the previous spec made explicit.
+type ConstSpec struct {
+	Names  []*ast.Ident
+	Type   ast.Expr
+	Values []ast.Expr
+	Iota   int
+}
+
+// ConstSpecs returns the specs of a const declaration. Specs
+// without values repeat the type and values of the previous spec,
+// with iota set to the index of the spec.
+func ConstSpecs(gen *ast.GenDecl) (res []ConstSpec) {
+	var typ ast.Expr
+	var values []ast.Expr
+	for i, sp := range gen.Specs {
+		vs := sp.(*ast.ValueSpec)
+		if vs.Values != nil {
+			typ, values = vs.Type, vs.Values
+		} else if vs.Type != nil {
+			util.Perrorf("const declaration cannot have type without expression")
+		}
+		res = append(res, ConstSpec{vs.Names, typ, values, i})
+	}
+	return
+}
+
+// AddConsts declares the constants of a const declaration in the scope.
+func (s *Scope) AddConsts(gen *ast.GenDecl) {
+	for _, spec := range ConstSpecs(gen) {
+		s.AddConstSpec(spec)
+	}
+}
+
+// AddConstSpec evaluates the values of the spec and
+// declares the constants in the scope.
+func (s *Scope) AddConstSpec(spec ConstSpec) {
+	if len(spec.Names) != len(spec.Values) {
+		util.Perrorf("assignment mismatch: %d constants but %d values", len(spec.Names), len(spec.Values))
+	}
+
+	scope := NewScope(s)
+	scope.AddVar(Symbol{Name: "iota", Type: UntypedInt, Const: constant.MakeInt64(int64(spec.Iota))})
+
+	for i, n := range spec.Names {
+		c, ok := scope.ConstValue(spec.Values[i])
+		if !ok {
+			util.Perrorf("%s is not constant", n.Name)
+		}
+		if spec.Type != nil {
+			c = c.Convert(s.ParseType(spec.Type))
+		}
+		if n.Name == "_" {
+			continue
+		}
+		if err := s.AddVar(Symbol{Name: n.Name, Type: c.Type, Id: s.VarSequence.Next(), Const: c.Value}); err != nil {
+			util.Perrorf("cannot add const %s: %s", n.Name, err)
+		}
+	}
+}
+
+// DeclareConsts declares the package level constants. Since they
+// can refer to constants declared later in the file, each spec is
+// evaluated only after the constants it depends on.
+func (v *ModuleVisitor) DeclareConsts(decls []ast.Decl) {
+	var pending []ConstSpec
+	undeclared := map[string]bool{}
+	for _, d := range decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.CONST {
+			for _, spec := range ConstSpecs(gen) {
+				pending = append(pending, spec)
+				for _, n := range spec.Names {
+					undeclared[n.Name] = true
+				}
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		var blocked []ConstSpec
+		for _, spec := range pending {
+			if DependsOn(spec.Values, undeclared) {
+				blocked = append(blocked, spec)
+				continue
+			}
+			func() {
+				defer ReportErrors(v.Scope, spec.Names[0])
+				v.AddConstSpec(spec)
+			}()
+			for _, n := range spec.Names {
+				delete(undeclared, n.Name)
+			}
+		}
+		if len(blocked) == len(pending) {
+			defer ReportErrors(v.Scope, blocked[0].Names[0])
+			util.Perrorf("initialization cycle involving constant %s", blocked[0].Names[0].Name)
+		}
+		pending = blocked
+	}
+}
+
+// DependsOn returns true if any of the expressions refers to one of the names.
+func DependsOn(exprs []ast.Expr, names map[string]bool) bool {
+	found := false
+	for _, e := range exprs {
+		ast.Inspect(e, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.SelectorExpr:
+				// only the left hand side can refer to a symbol
+				ast.Inspect(x.X, func(n ast.Node) bool {
+					if id, ok := n.(*ast.Ident); ok && names[id.Name] {
+						found = true
+					}
+					return true
+				})
+				return false
+			case *ast.Ident:
+				if names[x.Name] {
+					found = true
+				}
+			}
+			return true
+		})
+	}
+	return found
+}
@@ -42,9 +42,11 @@ func Dump(tree ast.Node, fileName string) {
 }
 
 func (s *Scope) DumpScope() {
-	fmt.Printf("Scope:\n")
-	for k, v := range s.Symbols {
-		fmt.Printf("%s : %#v\n", k, v)
+	for scope := s; scope != nil; scope = scope.Parent {
+		fmt.Printf("Scope:\n")
+		for k, v := range scope.Symbols {
+			fmt.Printf("%s : %#v\n", k, v)
+		}
+		fmt.Printf("end scope\n")
 	}
-	fmt.Printf("end scope\n")
 }
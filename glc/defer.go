@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Functions with deferred calls push a frame on entry, see
+// runtime/panic.c, and pop it when returning, which runs the
+// deferred calls. Their results live in memory, so that deferred
+// calls can modify them, and since panics recovered by deferred
+// calls longjmp to the frame to return from the function, with the
+// results set so far.
+
+// EmptyInterface is interface {}, the type of the values of panics.
+var EmptyInterface = &InterfaceType{}
+
+// HasDefer returns true if the body of a function has
+// defer statements, outside of function literals.
+func HasDefer(body *ast.BlockStmt) bool {
+	res := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.DeferStmt:
+			res = true
+		case *ast.FuncLit:
+			return false
+		}
+		return !res
+	})
+	return res
+}
+
+// PushFrame pushes the frame of a function with deferred calls,
+// whose recovered panics return from the function.
+func (v *BlockVisitor) PushFrame() {
+	setjmp := "_setjmp"
+	if !v.Externals[setjmp] {
+		v.Module.DeclareExternalAttrs(setjmp, lovm.FunctionType(lovm.IntType(32), false, BytePtr), "returns_twice")
+		v.Externals[setjmp] = true
+	}
+	pushframe := v.DeclareRuntime("goal_pushframe", BytePtr)
+	v.Frame = v.Builder.Call(BytePtr, pushframe)
+	jumped := v.Builder.Call(lovm.IntType(32), setjmp, v.Frame)
+
+	recovered := v.Function.NewBlock()
+	body := v.Function.NewBlock()
+	v.Builder.BranchIf(v.Builder.IICmp(lovm.IntNE, jumped, lovm.ConstInt(lovm.IntType(32), 0)), recovered, body)
+	recovered.Seal()
+	body.Seal()
+
+	v.Builder.SetInsertionPoint(recovered)
+	v.Return(nil)
+	v.Builder.SetInsertionPoint(body)
+}
+
+// Return returns values from the function. Functions with deferred
+// calls set their results to values, if not nil, run the deferred
+// calls and return the results.
+func (v *BlockVisitor) Return(values []lovm.Value) {
+	if v.Frame != nil {
+		results := v.FunctionType.Results
+		for i, value := range values {
+			v.WriteVar(results[i], value)
+		}
+		popframe := v.DeclareRuntime("goal_popframe", lovm.VoidType(), BytePtr)
+		v.Builder.Call(lovm.VoidType(), popframe, v.Frame)
+		values = make([]lovm.Value, len(results))
+		for i, sym := range results {
+			values[i] = v.ReadVar(sym)
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		v.Builder.Return(nil)
+	case 1:
+		v.Builder.Return(values[0])
+	default:
+		// multiple values are returned in a struct
+		var res lovm.Value = lovm.Undef(v.FunctionType.FuncType().ReturnType)
+		for i, val := range values {
+			res = v.Builder.InsertValue(res, val, i)
+		}
+		v.Builder.Return(res)
+	}
+}
+
+// SaveCall evaluates the function value and the arguments of the call
+// of a defer or go statement, which are saved in an environment. It
+// returns a new function taking the environment and making the call,
+// and the environment, as i8*.
+func (v *BlockVisitor) SaveCall(call *ast.CallExpr, stmt string) (lovm.Value, lovm.Value) {
+	if id, ok := ast.Unparen(call.Fun).(*ast.Ident); ok && v.IsBuiltin(id) {
+		util.Perrorf("NOT IMPLEMENTED YET: %s of builtin %s", stmt, id.Name)
+	}
+	if _, err := v.ResolveType(call.Fun); err == nil {
+		util.Perrorf("%s requires function call, not conversion", stmt)
+	}
+	name := types.ExprString(call.Fun)
+	fn := v.Evaluate(Any, call.Fun)
+	ft, ok := Underlying(fn.Type).(FunctionType)
+	if !ok {
+		util.Perrorf("cannot call non-function %s (type %v)", name, fn.Type)
+	}
+	args := v.Arguments(name, ft, call.Args)
+
+	fields := []Field{{Type: fn.Type}}
+	for _, p := range ft.Params {
+		fields = append(fields, Field{Type: p.Type})
+	}
+	env := StructType{fields}
+	ptr := v.Alloc(env)
+	for i, value := range append([]lovm.Value{fn.Value}, args...) {
+		v.Builder.Store(value, v.Builder.GEP(ptr, lovm.Indices(0, i)...))
+	}
+
+	v.Literals++
+	thunk := v.Module.NewFunction(fmt.Sprintf("%s.%s%d", v.Function.Name, stmt, v.Literals),
+		lovm.FunctionType(lovm.VoidType(), false, BytePtr))
+	builder := thunk.NewBuilder()
+	entry := thunk.NewBlock()
+	entry.Seal()
+	builder.SetInsertionPoint(entry)
+	saved := builder.BitCast(thunk.Param(0), lovm.PointerType(env.LlvmType()))
+	closure := builder.Load(builder.GEP(saved, lovm.Indices(0, 0)...))
+	callArgs := []lovm.Value{builder.ExtractValue(closure, 1)}
+	for i := range ft.Params {
+		callArgs = append(callArgs, builder.Load(builder.GEP(saved, lovm.Indices(0, i+1)...)))
+	}
+	llvmType := ClosureType(ft)
+	builder.CallIndirect(llvmType.ReturnType, builder.BitCast(builder.ExtractValue(closure, 0), lovm.PointerType(llvmType)), callArgs...)
+	builder.Return(nil)
+
+	return lovm.ConstBitCast(thunk.Ref(), BytePtr), v.Builder.BitCast(ptr, BytePtr)
+}
+
+// Defer compiles a defer statement, registering the
+// call to be made when the function returns.
+func (v *BlockVisitor) Defer(call *ast.CallExpr) {
+	fun, env := v.SaveCall(call, "defer")
+	deferproc := v.DeclareRuntime("goal_defer", lovm.VoidType(), BytePtr, BytePtr, BytePtr)
+	v.Builder.Call(lovm.VoidType(), deferproc, v.Frame, fun, env)
+}
+
+// Panic evaluates panic(x), which runs the deferred calls
+// of the goroutine until one of them recovers.
+func (v *ExpressionVisitor) Panic(call *ast.CallExpr) {
+	checkArgs(call, 1, 1)
+	x := v.BlockVisitor.Evaluate(EmptyInterface, call.Args[0])
+	if !x.AssignableTo(EmptyInterface) {
+		util.Perrorf("cannot use %v as interface {} value in argument to panic", x.Type)
+	}
+	panic := v.DeclareRuntime("goal_panic", lovm.VoidType(), BytePtr, BytePtr)
+	v.Builder.Call(lovm.VoidType(), panic, v.Builder.ExtractValue(x.Value, 0), v.Builder.ExtractValue(x.Value, 1))
+	v.Builder.Unreachable()
+	v.NewDeadBlock()
+	v.Type = Any
+}
+
+// Recover evaluates recover(), which stops the current panic and
+// returns its value when called by a deferred call, and nil otherwise.
+func (v *ExpressionVisitor) Recover(call *ast.CallExpr) {
+	checkArgs(call, 0, 0)
+	recover := v.DeclareRuntime("goal_recover", EmptyInterface.LlvmType())
+	v.Value = v.Builder.Call(EmptyInterface.LlvmType(), recover)
+	v.Type = EmptyInterface
+}
@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"goal/lovm"
 	"goal/util"
 	"log"
@@ -23,6 +25,17 @@ type Symbol struct {
 	Name string
 	Type Type
 	Id   util.Sequential
+	// value of constants, nil for variables
+	Const constant.Value
+	// address of variables living in memory, nil
+	// for variables held in ssa registers
+	Address lovm.Value
+	// true for symbols naming a type
+	TypeName bool
+	// true for declared functions, which are called directly
+	Func bool
+	// llvm name of declared functions
+	Link string
 }
 
 func (s Symbol) LlvmType() lovm.Type {
@@ -33,10 +46,15 @@ func (s Symbol) LlvmType() lovm.Type {
 type SymbolMap map[string]Symbol
 
 // visitors
+
+// A Scope holds the symbols declared in a block. Scopes
+// are chained from the innermost block outwards to the
+// function, package and universe scopes.
 type Scope struct {
 	*token.FileSet
 	Symbols     SymbolMap
 	VarSequence *util.Sequence
+	Parent      *Scope
 }
 
 func (s Scope) GetScope() Scope {
@@ -48,7 +66,7 @@ func NewScope(parent *Scope) Scope {
 }
 
 func NewFileSetScope(fset *token.FileSet, parent *Scope) Scope {
-	return Scope{fset, parent.Symbols, parent.VarSequence}
+	return Scope{fset, make(SymbolMap), parent.VarSequence, parent}
 }
 
 type Visitor interface {
@@ -65,22 +83,25 @@ func (s SkipRoot) Visit(ast.Node) ast.Visitor {
 }
 
 func Walk(visitor Visitor, node ast.Node) {
-	defer func() {
-		if err := recover(); err != nil {
-			switch e := err.(type) {
-			case error:
-				if strings.HasPrefix(e.Error(), "runtime error:") {
-					fmt.Fprintf(os.Stderr, "%s\n", e)
-					debug.PrintStack()
-					os.Exit(1)
-				}
+	defer ReportErrors(visitor.GetScope(), node)
+	ast.Walk(visitor, node)
+}
+
+// ReportErrors must be deferred. It reports compilation
+// errors at the position of node and exits.
+func ReportErrors(scope Scope, node ast.Node) {
+	if err := recover(); err != nil {
+		switch e := err.(type) {
+		case error:
+			if strings.HasPrefix(e.Error(), "runtime error:") {
+				fmt.Fprintf(os.Stderr, "%s\n", e)
+				debug.PrintStack()
+				os.Exit(1)
 			}
-			fmt.Fprintf(os.Stderr, "%s: %s\n", visitor.GetScope().Position(node.Pos()), err)
-			os.Exit(1)
 		}
-	}()
-
-	ast.Walk(visitor, node)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", scope.Position(node.Pos()), err)
+		os.Exit(1)
+	}
 }
 
 type ModuleVisitor struct {
@@ -88,6 +109,32 @@ type ModuleVisitor struct {
 	Module      *lovm.Module
 	PackageName string
 	VarSequence util.Sequence
+	// functions with a body, by declaration
+	Functions map[*ast.FuncDecl]DeclaredFunction
+	// package initialization, see globals.go
+	Init     *FunctionVisitor
+	UserInit []string
+	// declared runtime functions
+	Externals map[string]bool
+	// key descriptors of map types, by layout
+	KeyDescriptors map[string]lovm.Value
+	// names of the methods with pointer receivers, see methods.go
+	PointerMethods map[string]bool
+	// descriptors of the dynamic types of interface values and of
+	// interface types, and itabs, see interfaces.go
+	TypeDescriptors      map[string]lovm.Value
+	InterfaceDescriptors map[string]lovm.Value
+	Itabs                map[string]lovm.Value
+	// functions taking a closure environment, by
+	// the function they call, see closures.go
+	Adapters map[string]lovm.Value
+}
+
+// A DeclaredFunction is a function whose body
+// has yet to be compiled.
+type DeclaredFunction struct {
+	Type     FunctionType
+	Function *lovm.Function
 }
 
 // contains common state shared accross the function
@@ -97,13 +144,29 @@ type FunctionVisitor struct {
 	FunctionType FunctionType
 	Function     *lovm.Function
 	Builder      *lovm.Builder
+	// enclosing statements targeted by break and continue
+	Targets []BranchTarget
+	// variables living in memory, see pointers.go
+	Escaping map[*ast.Object]bool
+	// number of function literals and deferred calls
+	Literals int
+	// frame of functions with deferred calls, see defer.go
+	Frame lovm.Value
+}
+
+// A BranchTarget is a statement which can be the target
+// of a break or continue. Continue is nil for statements
+// which are not loops.
+type BranchTarget struct {
+	Label    string
+	Break    *lovm.Block
+	Continue *lovm.Block
 }
 
 // contains scope local to a block
 type BlockVisitor struct {
 	Scope
 	*FunctionVisitor
-	Block *lovm.Block
 }
 
 type ExpressionVisitor struct {
@@ -117,33 +180,15 @@ func (v *ModuleVisitor) Visit(node ast.Node) ast.Visitor {
 	if node != nil {
 		switch n := node.(type) {
 		case *ast.FuncDecl:
-			functionType := v.ParseFuncType(n.Type)
-			llvmFunction := v.Module.NewFunction(n.Name.Name, functionType.LlvmType())
-			// TODO(mkm) put it back
-			//if err := v.AddVar(Symbol{Name: n.Name.Name, Type: functionType, Value: llvmFunction}); err != nil {
-			//	util.Perrorf("cannot add symbol %#v: %s", n.Name.Name, err)
-			//}
+			functionType := v.Functions[n].Type
+			llvmFunction := v.Functions[n].Function
 
 			if n.Body != nil {
-				builder := llvmFunction.NewBuilder()
-
-				entry := llvmFunction.NewBlock()
-				builder.SetInsertionPoint(entry)
-
-				newScope := NewScope(&v.Scope)
-				for i, p := range functionType.Params {
-					if p.Name != "" {
-						if err := newScope.AddVar(p); err != nil {
-							util.Perrorf("cannot add symbol %#v: %s", p, err)
-						}
-						value := llvmFunction.Param(i)
-						entry.Assign(p, value)
-					}
-				}
-
-				fv := &FunctionVisitor{v, nil, functionType, llvmFunction, builder}
-				bv := &BlockVisitor{newScope, fv, entry}
-				Walk(SkipRoot{bv}, n.Body)
+				fv := v.NewFunctionVisitor(functionType, llvmFunction, AddressTaken(n, v.PointerMethods))
+				bv := &BlockVisitor{NewScope(&v.Scope), fv}
+				// the receiver of methods is the first parameter
+				params := append(FieldIdents(n.Recv), FieldIdents(n.Type.Params)...)
+				bv.CompileBody(params, FieldIdents(n.Type.Results), 0, n.Body)
 
 				// debug
 				// TODO(mkm): put it back somehow
@@ -155,7 +200,18 @@ func (v *ModuleVisitor) Visit(node ast.Node) ast.Visitor {
 		case *ast.DeclStmt:
 			util.Perrorf("Unimplemented decl stmt")
 		case *ast.File:
-			// root element, nothing to do
+			// declare all the types, constants and functions before compiling
+			// any body, so that they can be used before being defined.
+			types := v.DeclareTypes(n.Decls)
+			v.DeclareConsts(n.Decls)
+			v.ResolveTypes(types)
+			for _, d := range n.Decls {
+				if fd, ok := d.(*ast.FuncDecl); ok {
+					v.DeclareFunction(fd)
+				}
+			}
+			v.DeclareGlobals(n.Decls)
+			v.FinishInit()
 			return v
 		case *ast.Ident:
 			if v.PackageName != "" {
@@ -167,6 +223,8 @@ func (v *ModuleVisitor) Visit(node ast.Node) ast.Visitor {
 			switch n.Tok {
 			case token.IMPORT:
 				// ignore imports for now
+			case token.CONST, token.VAR, token.TYPE:
+				// already declared
 			default:
 				util.Perrorf("UNIMPLEMENTED UNKNOWN GENDECL: %#v", node)
 			}
@@ -180,43 +238,364 @@ func (v *ModuleVisitor) Visit(node ast.Node) ast.Visitor {
 	return nil
 }
 
+// DeclareFunction adds the function to the module scope.
+// Functions without a body are declared as externals.
+func (v *ModuleVisitor) DeclareFunction(n *ast.FuncDecl) {
+	defer ReportErrors(v.Scope, n)
+	name := n.Name.Name
+	functionType := v.ParseFuncType(n.Type)
+	if n.Recv != nil {
+		v.DeclareMethod(n, functionType)
+		return
+	}
+	if name == "init" {
+		// init functions cannot be referred to, and there can be many
+		if len(functionType.Params) != 0 || len(functionType.Results) != 0 {
+			util.Perrorf("func init must have no arguments and no return values")
+		}
+		name = fmt.Sprintf("%s.init.%d", v.Module.Name, len(v.UserInit))
+		v.UserInit = append(v.UserInit, name)
+		v.Functions[n] = DeclaredFunction{functionType, v.Module.NewFunction(name, functionType.FuncType())}
+		return
+	}
+
+	// functions are qualified by the package so as not to clash with
+	// the runtime and libc, but for the entry point and the externals
+	link := fmt.Sprintf("%s.%s", v.Module.Name, name)
+	if n.Body == nil || (v.Module.Name == "main" && name == "main") {
+		link = name
+	}
+	if n.Body == nil {
+		v.Module.DeclareExternal(link, functionType.FuncType())
+	} else {
+		v.Functions[n] = DeclaredFunction{functionType, v.Module.NewFunction(link, functionType.FuncType())}
+	}
+
+	if err := v.AddVar(Symbol{Name: name, Type: functionType, Id: v.VarSequence.Next(), Func: true, Link: link}); err != nil {
+		util.Perrorf("cannot add symbol %#v: %s", name, err)
+	}
+}
+
+// NewFunctionVisitor returns a visitor emitting the body
+// of fun, of type ft, starting from its entry block.
+func (v *ModuleVisitor) NewFunctionVisitor(ft FunctionType, fun *lovm.Function, escaping map[*ast.Object]bool) *FunctionVisitor {
+	builder := fun.NewBuilder()
+	entry := fun.NewBlock()
+	entry.Seal()
+	builder.SetInsertionPoint(entry)
+	return &FunctionVisitor{v, nil, ft, fun, builder, nil, escaping, 0, nil}
+}
+
+// CompileBody declares the parameters and the named results of the
+// function, whose values are the parameters of the llvm function
+// starting from first, and compiles its body.
+func (v *BlockVisitor) CompileBody(params, results []*ast.Ident, first int, body *ast.BlockStmt) {
+	functionType := v.FunctionType
+	for i, id := range params {
+		if id != nil && id.Name != "_" {
+			v.DeclareSymbol(functionType.Params[i], id.Obj, v.Function.Param(first+i))
+		}
+	}
+	// named results are read back by bare returns, and with deferred
+	// calls all the results live in memory, see defer.go
+	deferring := HasDefer(body)
+	v.FunctionType.Results = make([]Symbol, len(functionType.Results))
+	for i, id := range results {
+		r := functionType.Results[i]
+		if id != nil {
+			if deferring {
+				v.Escaping[id.Obj] = true
+			}
+			r = v.DeclareSymbol(r, id.Obj, ZeroValue(r.Type))
+		} else if deferring {
+			r.Address = v.Alloc(r.Type)
+		}
+		v.FunctionType.Results[i] = r
+	}
+	if deferring {
+		v.PushFrame()
+	}
+	Walk(SkipRoot{v}, body)
+
+	if !v.Builder.GetInsertBlock().Terminated() {
+		if len(functionType.Results) == 0 {
+			v.Return(nil)
+		} else {
+			v.Builder.Unreachable()
+		}
+	}
+}
+
 func (s *BlockVisitor) AddDecl(d ast.Decl) error {
 	gen := d.(*ast.GenDecl)
+	switch gen.Tok {
+	case token.CONST:
+		s.AddConsts(gen)
+		return nil
+	case token.TYPE:
+		s.AddTypes(gen)
+		return nil
+	}
 
 	for _, sp := range gen.Specs {
 		vs := sp.(*ast.ValueSpec)
-		for idx, n := range vs.Names {
-			if vs.Type == nil {
-				util.Perrorf("cannot declare a var without a type")
+
+		var typ Type
+		if vs.Type != nil {
+			typ = s.ParseType(vs.Type)
+		}
+
+		var results []*ExpressionVisitor
+		if vs.Values != nil {
+			hints := make([]Type, len(vs.Names))
+			for i := range hints {
+				hints[i] = typ
+				if typ == nil {
+					hints[i] = Any
+				}
 			}
-			typ := s.ParseType(vs.Type)
+			results = s.EvaluateValues(hints, vs.Values)
+		} else if typ == nil {
+			util.Perrorf("missing type or init expr")
+		}
+
+		for idx, n := range vs.Names {
+			varType := typ
 			var value lovm.Value
-			if vs.Values != nil {
-				ev := &ExpressionVisitor{s, nil, typ}
-				Walk(ev, vs.Values[idx])
+			if results != nil {
+				ev := results[idx]
+				if varType == nil {
+					varType = ev.Type
+				} else if !ev.AssignableTo(varType) {
+					util.Perrorf("cannot use %v as %v value in variable declaration", ev.Type, varType)
+				}
 				value = ev.Value
 			} else {
-				value = lovm.ConstInt(typ.LlvmType(), 0)
+				value = ZeroValue(typ)
 			}
-			sym := Symbol{Name: n.Name, Type: typ}
-			if err := s.AddVar(sym); err != nil {
-				util.Perrorf("cannot add var %s: %s", n.Name, err)
+			if n.Name == "_" {
+				continue
 			}
-			s.Block.Assign(sym, value)
+			s.DeclareVar(n, varType, value)
 		}
 	}
 	return nil
 }
 
+// DeclareVar adds a new variable to the scope, initialized with value.
+func (s *BlockVisitor) DeclareVar(id *ast.Ident, typ Type, value lovm.Value) Symbol {
+	if typ == Any {
+		util.Perrorf("%s declared with a value of no type", id.Name)
+	}
+	return s.DeclareSymbol(Symbol{Name: id.Name, Type: typ, Id: s.VarSequence.Next()}, id.Obj, value)
+}
+
+// DeclareSymbol adds a variable to the scope, initialized with value.
+// Variables whose address is taken are allocated on the heap.
+func (s *BlockVisitor) DeclareSymbol(sym Symbol, obj *ast.Object, value lovm.Value) Symbol {
+	if s.Escaping[obj] {
+		sym.Address = s.Alloc(sym.Type)
+	}
+	if err := s.AddVar(sym); err != nil {
+		util.Perrorf("cannot add var %s: %s", sym.Name, err)
+	}
+	s.WriteVar(sym, value)
+	return sym
+}
+
+// ReadVar returns the current value of a variable.
+func (s *BlockVisitor) ReadVar(sym Symbol) lovm.Value {
+	if sym.Address != nil {
+		return s.Builder.Load(sym.Address)
+	}
+	return s.Builder.Ref(sym.LlvmType(), sym.Id)
+}
+
+// WriteVar assigns a new value to a variable.
+func (s *BlockVisitor) WriteVar(sym Symbol, value lovm.Value) {
+	if sym.Address != nil {
+		s.Builder.Store(value, sym.Address)
+		return
+	}
+	s.Builder.Assign(sym.Id, value)
+}
+
+// An LValue is a location which can be assigned to. The operands
+// locating it are evaluated when the LValue is built, while Load
+// and Store access the current value of the location.
+type LValue struct {
+	Type Type
+	// address of locations in memory, nil for
+	// variables held in ssa registers
+	Address lovm.Value
+	Load    func() lovm.Value
+	Store   func(lovm.Value)
+}
+
+// Addressable returns the location denoted by the left hand side
+// of an assignment. The blank identifier has type Any and discards
+// the values stored into it.
+func (v *BlockVisitor) Addressable(e ast.Expr) LValue {
+	switch n := e.(type) {
+	case *ast.ParenExpr:
+		return v.Addressable(n.X)
+	case *ast.Ident:
+		if n.Name == "_" {
+			return LValue{Any, nil, nil, func(lovm.Value) {}}
+		}
+		sym := v.ResolveSymbol(n.Name)
+		if sym.TypeName {
+			util.Perrorf("cannot assign to %s", n.Name)
+		}
+		if sym.Const == nil {
+			return LValue{
+				sym.Type,
+				sym.Address,
+				func() lovm.Value { return v.ReadVar(sym) },
+				func(value lovm.Value) { v.WriteVar(sym, value) },
+			}
+		}
+		// constants can be indexed, as in c[i]
+	case *ast.StarExpr:
+		return v.MemoryLValue(v.Dereference(n.X))
+	case *ast.IndexExpr:
+		return v.IndexLValue(n)
+	case *ast.SelectorExpr:
+		x := v.Addressable(n.X)
+		if ptr, ok := Underlying(x.Type).(PointerType); ok {
+			// p.f is (*p).f
+			p := x.Load()
+			v.NilCheck(p, n.Sel.Pos())
+			x = v.MemoryLValue(p, ptr.Elem)
+		}
+		idx, field := FieldByName(x.Type, n.Sel.Name)
+		if x.Address != nil {
+			return v.MemoryLValue(v.Builder.GEP(x.Address, lovm.Indices(0, idx)...), field.Type)
+		}
+		// fields of structs held in registers are updated
+		// by storing a copy of the whole struct
+		return LValue{
+			field.Type,
+			nil,
+			func() lovm.Value { return v.Builder.ExtractValue(x.Load(), idx) },
+			func(value lovm.Value) { x.Store(v.Builder.InsertValue(x.Load(), value, idx)) },
+		}
+	}
+	// not addressable, but can be the operand of
+	// an addressable expression, as in f().x
+	ev := v.Evaluate(Any, e)
+	return LValue{
+		ev.Type,
+		nil,
+		func() lovm.Value { return ev.Value },
+		func(lovm.Value) { util.Perrorf("cannot assign to %s", types.ExprString(e)) },
+	}
+}
+
+// UpdateAssign compiles x op= y, evaluating
+// the operands of x only once.
+func (v *BlockVisitor) UpdateAssign(x ast.Expr, op token.Token, y ast.Expr) {
+	lv := v.Addressable(x)
+	if lv.Type == Any {
+		util.Perrorf("cannot use _ as value")
+	}
+	xev := &ExpressionVisitor{v, lv.Load(), lv.Type}
+	yev := v.Evaluate(lv.Type, y)
+	ev := &ExpressionVisitor{v, nil, lv.Type}
+	ev.BinaryOp(op, xev, yev, y.Pos())
+	lv.Store(ev.Value)
+}
+
+// Define compiles a short variable declaration. At least one of the
+// variables on the left must be new; the others are assigned to.
+func (v *BlockVisitor) Define(n *ast.AssignStmt) {
+	hints := make([]Type, len(n.Lhs))
+	for i, e := range n.Lhs {
+		id, ok := e.(*ast.Ident)
+		if !ok {
+			util.Perrorf("non-name on left side of :=")
+		}
+		hints[i] = Any
+		if sym, ok := v.Symbols[id.Name]; ok {
+			hints[i] = sym.Type
+		}
+	}
+
+	results := v.EvaluateValues(hints, n.Rhs)
+
+	declared := false
+	for i, e := range n.Lhs {
+		name := e.(*ast.Ident).Name
+		if name == "_" {
+			continue
+		}
+		ev := results[i]
+		if sym, ok := v.Symbols[name]; ok {
+			if !ev.AssignableTo(sym.Type) {
+				util.Perrorf("cannot use %v as %v value in assignment", ev.Type, sym.Type)
+			}
+			v.WriteVar(sym, ev.Value)
+		} else {
+			v.DeclareVar(e.(*ast.Ident), ev.Type, ev.Value)
+			declared = true
+		}
+	}
+	if !declared {
+		util.Perrorf("no new variables on left side of :=")
+	}
+}
+
+// EvaluateValues evaluates the right hand side of an assignment
+// or declaration to as many values as there are hints, either one
+// per expression or unpacking a single multi-valued expression.
+// Each expression is evaluated with the corresponding type hint.
+func (v *BlockVisitor) EvaluateValues(hints []Type, exprs []ast.Expr) []*ExpressionVisitor {
+	if len(exprs) == 1 && len(hints) == 2 {
+		if results, ok := v.CommaOk(exprs[0]); ok {
+			return results
+		}
+	}
+	if len(exprs) == 1 && len(hints) > 1 {
+		// a, b = f()
+		results := v.Unpack(exprs[0])
+		if len(results) != len(hints) {
+			util.Perrorf("assignment mismatch: %d variables but %d values", len(hints), len(results))
+		}
+		return results
+	}
+	if len(exprs) != len(hints) {
+		util.Perrorf("assignment mismatch: %d variables but %d values", len(hints), len(exprs))
+	}
+	if len(exprs) == 1 {
+		// x = f(), where f must have a single result
+		ev := v.EvaluateMultiValue(hints[0], exprs[0])
+		if tuple, ok := ev.Type.(TupleType); ok {
+			util.Perrorf("assignment mismatch: 1 variable but %s returns %d values", types.ExprString(exprs[0]), len(tuple.Types))
+		}
+		return []*ExpressionVisitor{ev}
+	}
+	results := make([]*ExpressionVisitor, len(exprs))
+	for i, e := range exprs {
+		results[i] = v.Evaluate(hints[i], e)
+	}
+	return results
+}
+
+// ResolveSymbol looks up name in the scope and then in
+// the enclosing ones.
 func (s *Scope) ResolveSymbol(name string) Symbol {
-	if res, ok := s.Symbols[name]; ok {
-		return res
+	for scope := s; scope != nil; scope = scope.Parent {
+		if res, ok := scope.Symbols[name]; ok {
+			return res
+		}
 	}
 
 	util.Perrorf("cannot resolve symbol: %s", name)
 	return Symbol{}
 }
 
+// AddVar declares a symbol in the scope, possibly
+// shadowing a symbol of an enclosing scope.
 func (s *Scope) AddVar(variable Symbol) error {
 	name := variable.Name
 	if _, ok := s.Symbols[name]; ok {
@@ -226,29 +605,33 @@ func (s *Scope) AddVar(variable Symbol) error {
 	return nil
 }
 
-func (v *ExpressionVisitor) IsConst(node ast.Node) bool {
-	switch e := node.(type) {
-	case *ast.BasicLit:
-		return true
-	case *ast.ParenExpr:
-		return v.IsConst(e.X)
-	default:
-		return false
-	}
+func (v *ExpressionVisitor) IsConst(node ast.Expr) bool {
+	_, ok := v.ConstValue(node)
+	return ok
 }
 
 func (v *ExpressionVisitor) Visit(node ast.Node) ast.Visitor {
 	if node != nil {
+		if e, ok := node.(ast.Expr); ok {
+			// constant expressions are folded
+			if c, ok := v.ConstValue(e); ok {
+				v.Value, v.Type = c.Emit(v.Module, v.Type)
+				return nil
+			}
+		}
+
 		switch n := node.(type) {
 		case *ast.ParenExpr:
 			return v
 		case *ast.BinaryExpr:
-			if v.IsConst(n.X) && v.IsConst(n.Y) {
-				util.Perrorf("expression with only constants not implemented yet...")
+			if n.Op == token.LAND || n.Op == token.LOR {
+				v.LogicalOp(n)
+				return nil
 			}
 
 			var xev, yev *ExpressionVisitor
-			if v.IsConst(n.X) {
+			if v.IsConst(n.X) || v.IsNil(n.X) {
+				// untyped operands take the type of the other one
 				yev = v.Evaluate(n.Y)
 				v.Type = yev.Type
 				xev = v.Evaluate(n.X)
@@ -258,97 +641,90 @@ func (v *ExpressionVisitor) Visit(node ast.Node) ast.Visitor {
 				yev = v.Evaluate(n.Y)
 			}
 
-			if xev.Type == Any {
-				xev.Type = yev.Type
-			}
-			if yev.Type == Any {
-				yev.Type = xev.Type
+			if (n.Op == token.EQL || n.Op == token.NEQ) && !v.IsNil(n.X) && !v.IsNil(n.Y) && !Comparable(xev.Type) {
+				util.Perrorf("invalid operation: %s (%v cannot be compared)", types.ExprString(n), xev.Type)
 			}
-
-			if xev.Type != yev.Type {
-				util.Perrorf("Types %#v and %#v are not compatible (A)", xev.Type, yev.Type)
-			}
-			// types must match, thus take either one
-			v.Type = xev.Type
+			v.BinaryOp(n.Op, xev, yev, n.OpPos)
+			return nil
+		case *ast.BasicLit:
+			util.Perrorf("Unimplemented literal: %#v", n)
+		case *ast.SelectorExpr:
+			v.Selector(n)
+			return nil
+		case *ast.CompositeLit:
+			v.CompositeLit(n)
+			return nil
+		case *ast.UnaryExpr:
 			switch n.Op {
-			case token.ADD:
-				v.Value = v.Builder.IAdd(xev.Value, yev.Value)
-			case token.SUB:
-				v.Value = v.Builder.ISub(xev.Value, yev.Value)
-			case token.MUL:
-				v.Value = v.Builder.IMul(xev.Value, yev.Value)
-			case token.QUO:
-				v.Value = v.Builder.ISDiv(xev.Value, yev.Value)
-			case token.REM:
-				v.Value = v.Builder.ISRem(xev.Value, yev.Value)
-			case token.LSS:
-				v.Value = v.Builder.IICmp(lovm.IntSLT, xev.Value, yev.Value)
-				v.Type = Bool
-			case token.GTR:
-				v.Value = v.Builder.IICmp(lovm.IntSGT, xev.Value, yev.Value)
-				v.Type = Bool
+			case token.AND:
+				v.AddressOf(n.X)
+			case token.ARROW:
+				v.Receive(n.X)
+			case token.NOT, token.SUB, token.ADD, token.XOR:
+				v.UnaryOp(n)
 			default:
-				util.Perrorf("inimplemented binary operator %v", n.Op)
+				util.Perrorf("unimplemented unary operator %v", n.Op)
 			}
-
 			return nil
-		case *ast.BasicLit:
-			if v.Type == nil {
-				// panic(fmt.Errorf("runtime error: constant without type info")
-				v.Type = Int
-				fmt.Printf("XXXXXXXXXXXXXXXXXXXXXXXX %#v", v.Type)
-			}
-
-			if v.Type == Bool {
-				util.Perrorf("Boolean arithmetic is not allowed: %#v", n)
+		case *ast.IndexExpr:
+			lv := v.IndexLValue(n)
+			v.Value, v.Type = lv.Load(), lv.Type
+			return nil
+		case *ast.SliceExpr:
+			v.SliceExpr(n)
+			return nil
+		case *ast.TypeAssertExpr:
+			x, typ := v.AssertionOperand(n)
+			v.Value, _ = v.AssertType(x, typ, false)
+			v.Type = typ
+			return nil
+		case *ast.StarExpr:
+			ptr, typ := v.Dereference(n.X)
+			v.Value = v.Builder.Load(ptr)
+			v.Type = typ
+			return nil
+		case *ast.Ident:
+			if v.IsNil(n) {
+				v.Nil()
+				return nil
 			}
-			switch n.Kind {
-			case token.INT:
-				v.Value = lovm.ConstIntFromString(v.Type.LlvmType(), n.Value, 10)
-			case token.STRING:
-				v.Value = v.Module.ConstString(n.Value)
-			default:
-				util.Perrorf("Unimplemented literal: %#v", n)
+			symbol := v.ResolveSymbol(n.Name)
+			if symbol.TypeName {
+				util.Perrorf("%s (type) is not an expression", n.Name)
 			}
-		case *ast.Ident:
-			if n.Name == "true" {
-				v.Type = Bool
-				v.Value = lovm.ConstInt(v.Type.LlvmType(), 1)
-			} else if n.Name == "false" {
-				v.Type = Bool
-				v.Value = lovm.ConstInt(v.Type.LlvmType(), 0)
-			} else {
-				symbol := v.ResolveSymbol(n.Name)
-				v.Type = symbol.Type
-				v.Value = v.Builder.Ref(v.Type.LlvmType(), symbol)
+			v.Type = symbol.Type
+			if symbol.Func {
+				v.Value = v.FuncValue(symbol)
+				return nil
 			}
+			v.Value = v.ReadVar(symbol)
+			return nil
+		case *ast.FuncLit:
+			v.FuncLit(n)
 			return nil
 		case *ast.CallExpr:
+			if typ, err := v.ResolveType(n.Fun); err == nil {
+				if len(n.Args) != 1 {
+					util.Perrorf("type conversion can have only one argument")
+				}
+				v.Convert(v.BlockVisitor.Evaluate(typ, n.Args[0]), typ)
+				return nil
+			}
 			if id, ok := n.Fun.(*ast.Ident); ok {
-				if typ, err := v.ResolveType(id); err == nil {
-					if len(n.Args) != 1 {
-						util.Perrorf("type conversion can have only one argument")
-					}
-					ev := v.Evaluate(n.Args[0])
-					util.Perrorf("not migrated to new api: %v, %v", typ, ev)
-					//v.Value = v.Builder.CreateIntCast(ev.Value, typ.LlvmType(), "")
-				} else {
-					fs := v.ResolveSymbol(id.Name)
-					if _, ok := fs.Type.(FunctionType); !ok {
-						util.Perrorf("Calling a non function")
-					}
-					args := []lovm.Value{}
-					for _, a := range n.Args {
-						ex := v.Evaluate(a)
-						// TODO(mkm) check types
-						args = append(args, ex.Value)
-					}
-					util.Perrorf("not migrated to new api")
-					//v.Value = v.Builder.Call(*fs.Value, args)
+				if v.IsBuiltin(id) {
+					v.CallBuiltin(id, n)
+					return nil
+				}
+				if fs := v.ResolveSymbol(id.Name); fs.Func {
+					v.CallFunction(fs.Link, nil, fs.Type.(FunctionType), nil, n.Args)
+					return nil
 				}
+			}
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+				v.CallSelector(sel, n.Args)
 				return nil
 			}
-			util.Perrorf("Unimplemented call %#v", node)
+			v.CallClosure(v.BlockVisitor.Evaluate(Any, n.Fun), types.ExprString(n.Fun), n.Args)
 		default:
 			util.Perrorf("----- Expression visitor: UNKNOWN %#v\n", node)
 			return v
@@ -357,52 +733,564 @@ func (v *ExpressionVisitor) Visit(node ast.Node) ast.Visitor {
 	return nil
 }
 
+// Convert converts a value to type typ. Integers are
+// truncated or extended according to the signedness of
+// the source type.
+func (v *ExpressionVisitor) Convert(ev *ExpressionVisitor, typ Type) {
+	from, to := Underlying(ev.Type), Underlying(typ)
+	v.Type = typ
+	switch {
+	case Identical(from, to):
+		v.Value = ev.Value
+	case IsInteger(from) && IsInteger(to):
+		fromBits, toBits := IntegerBits(from), IntegerBits(to)
+		switch {
+		case fromBits > toBits:
+			v.Value = v.Builder.Trunc(ev.Value, to.LlvmType())
+		case fromBits < toBits && from.(PrimitiveType).Signed:
+			v.Value = v.Builder.SExt(ev.Value, to.LlvmType())
+		case fromBits < toBits:
+			v.Value = v.Builder.ZExt(ev.Value, to.LlvmType())
+		default:
+			v.Value = ev.Value
+		}
+	default:
+		if _, ok := to.(*InterfaceType); ok && ev.AssignableTo(typ) {
+			v.Value = ev.Value
+		} else if !v.ConvertString(ev, typ) {
+			util.Perrorf("cannot convert %v to %v", ev.Type, typ)
+		}
+	}
+}
+
+// predicates of the ordered comparisons of signed and unsigned integers
+var (
+	intPredicates = map[token.Token]string{
+		token.LSS: lovm.IntSLT,
+		token.LEQ: lovm.IntSLE,
+		token.GTR: lovm.IntSGT,
+		token.GEQ: lovm.IntSGE,
+	}
+	unsignedPredicates = map[token.Token]string{
+		token.LSS: lovm.IntULT,
+		token.LEQ: lovm.IntULE,
+		token.GTR: lovm.IntUGT,
+		token.GEQ: lovm.IntUGE,
+	}
+)
+
+// LogicalOp evaluates x && y or x || y. The right operand is
+// evaluated only if the left one doesn't determine the result,
+// which merges the values of the operands.
+func (v *ExpressionVisitor) LogicalOp(n *ast.BinaryExpr) {
+	hint := v.Type
+	if Underlying(hint) != Bool {
+		hint = Bool
+	}
+	x := v.BlockVisitor.Evaluate(hint, n.X)
+	if Underlying(x.Type) != Bool {
+		util.Perrorf("invalid operation: operator %v not defined on %s (type %v)", n.Op, types.ExprString(n.X), x.Type)
+	}
+	res := Symbol{Type: Bool, Id: v.VarSequence.Next()}
+	v.WriteVar(res, x.Value)
+
+	rhs := v.Function.NewBlock()
+	end := v.Function.NewBlock()
+	if n.Op == token.LAND {
+		v.Builder.BranchIf(x.Value, rhs, end)
+	} else {
+		v.Builder.BranchIf(x.Value, end, rhs)
+	}
+	rhs.Seal()
+
+	v.Builder.SetInsertionPoint(rhs)
+	y := v.BlockVisitor.Evaluate(x.Type, n.Y)
+	if Underlying(y.Type) != Bool {
+		util.Perrorf("invalid operation: operator %v not defined on %s (type %v)", n.Op, types.ExprString(n.Y), y.Type)
+	}
+	if v.IsConst(n.X) {
+		// untyped constants take the type of the other operand
+		x.Type = y.Type
+	}
+	if !Identical(x.Type, y.Type) {
+		util.Perrorf("invalid operation: %s (mismatched types %v and %v)", types.ExprString(n), x.Type, y.Type)
+	}
+	v.WriteVar(res, y.Value)
+	v.Builder.Branch(end)
+	end.Seal()
+
+	v.Builder.SetInsertionPoint(end)
+	v.Value = v.ReadVar(res)
+	v.Type = x.Type
+}
+
+// UnaryOp evaluates !x, -x, +x and ^x, which are
+// not constant since constants are folded.
+func (v *ExpressionVisitor) UnaryOp(n *ast.UnaryExpr) {
+	x := v.BlockVisitor.Evaluate(v.Type, n.X)
+	if n.Op == token.NOT {
+		if Underlying(x.Type) != Bool {
+			util.Perrorf("invalid operation: operator ! not defined on %s (type %v)", types.ExprString(n.X), x.Type)
+		}
+		v.Value = v.Builder.Xor(x.Value, lovm.ConstInt(Bool.LlvmType(), 1))
+		v.Type = x.Type
+		return
+	}
+	if !IsInteger(x.Type) {
+		util.Perrorf("invalid operation: operator %v not defined on %s (type %v)", n.Op, types.ExprString(n.X), x.Type)
+	}
+	switch n.Op {
+	case token.SUB:
+		v.Value = v.Builder.ISub(lovm.ConstInt(x.Type.LlvmType(), 0), x.Value)
+	case token.XOR:
+		// all ones
+		v.Value = v.Builder.Xor(x.Value, lovm.ConstInt(x.Type.LlvmType(), -1))
+	default:
+		v.Value = x.Value
+	}
+	v.Type = x.Type
+}
+
+// DivideCheck panics at runtime if the divisor y of the
+// division at the source position pos is zero. Constant
+// divisors are checked at compile time.
+func (v *BlockVisitor) DivideCheck(y lovm.Value, pos token.Pos) {
+	if c, ok := y.(lovm.Const); ok {
+		if c.Val == "0" {
+			util.Perrorf("invalid operation: division by zero")
+		}
+		return
+	}
+	fail := v.Function.NewBlock()
+	ok := v.Function.NewBlock()
+	v.Builder.BranchIf(v.Builder.IICmp(lovm.IntEQ, y, lovm.ConstInt(y.Type(), 0)), fail, ok)
+	fail.Seal()
+	ok.Seal()
+
+	v.Builder.SetInsertionPoint(fail)
+	panicDivide := v.DeclareRuntime("goal_panic_divide", lovm.VoidType(), BytePtr)
+	v.Builder.Call(lovm.VoidType(), panicDivide, v.SourcePosition(pos))
+	v.Builder.Unreachable()
+
+	v.Builder.SetInsertionPoint(ok)
+}
+
+// BinaryOp computes x op y on values of the same type, for
+// the operator at the source position pos.
+func (v *ExpressionVisitor) BinaryOp(op token.Token, xev, yev *ExpressionVisitor, pos token.Pos) {
+	if xev.Type == Any {
+		xev.Type = yev.Type
+	}
+	if yev.Type == Any {
+		yev.Type = xev.Type
+	}
+
+	if op == token.EQL || op == token.NEQ {
+		// interfaces can be compared to values implementing them
+		if _, ok := Underlying(xev.Type).(*InterfaceType); ok {
+			yev.AssignableTo(xev.Type)
+		} else if _, ok := Underlying(yev.Type).(*InterfaceType); ok {
+			xev.AssignableTo(yev.Type)
+		}
+	}
+	if !Identical(xev.Type, yev.Type) {
+		util.Perrorf("Types %#v and %#v are not compatible (A)", xev.Type, yev.Type)
+	}
+	// types must match, thus take either one
+	v.Type = xev.Type
+	if Underlying(v.Type) == String {
+		v.StringOp(op, xev, yev)
+		return
+	}
+	if op != token.EQL && op != token.NEQ && !IsInteger(v.Type) {
+		util.Perrorf("invalid operation: operator %v not defined on %v", op, v.Type)
+	}
+	switch op {
+	case token.ADD:
+		v.Value = v.Builder.IAdd(xev.Value, yev.Value)
+	case token.SUB:
+		v.Value = v.Builder.ISub(xev.Value, yev.Value)
+	case token.MUL:
+		v.Value = v.Builder.IMul(xev.Value, yev.Value)
+	case token.QUO:
+		v.DivideCheck(yev.Value, pos)
+		if Underlying(v.Type).(PrimitiveType).Signed {
+			v.Value = v.Builder.ISDiv(xev.Value, yev.Value)
+		} else {
+			v.Value = v.Builder.IUDiv(xev.Value, yev.Value)
+		}
+	case token.REM:
+		v.DivideCheck(yev.Value, pos)
+		if Underlying(v.Type).(PrimitiveType).Signed {
+			v.Value = v.Builder.ISRem(xev.Value, yev.Value)
+		} else {
+			v.Value = v.Builder.IURem(xev.Value, yev.Value)
+		}
+	case token.EQL:
+		v.Value = v.Equal(xev.Value, yev.Value, xev.Type)
+		v.Type = Bool
+	case token.NEQ:
+		v.Value = v.Builder.Xor(v.Equal(xev.Value, yev.Value, xev.Type), lovm.ConstInt(Bool.LlvmType(), 1))
+		v.Type = Bool
+	case token.LSS, token.LEQ, token.GTR, token.GEQ:
+		pred := intPredicates[op]
+		if !Underlying(xev.Type).(PrimitiveType).Signed {
+			pred = unsignedPredicates[op]
+		}
+		v.Value = v.Builder.IICmp(pred, xev.Value, yev.Value)
+		v.Type = Bool
+	default:
+		util.Perrorf("inimplemented binary operator %v", op)
+	}
+}
+
+// Equal compares two values of type typ. Structs and arrays
+// are equal if all their non-blank fields or elements are equal.
+func (v *BlockVisitor) Equal(x, y lovm.Value, typ Type) lovm.Value {
+	switch t := Underlying(typ).(type) {
+	case StructType:
+		var res lovm.Value = lovm.ConstInt(Bool.LlvmType(), 1)
+		for i, f := range t.Fields {
+			if f.Name == "_" {
+				continue
+			}
+			eq := v.Equal(v.Builder.ExtractValue(x, i), v.Builder.ExtractValue(y, i), f.Type)
+			res = v.Builder.And(res, eq)
+		}
+		return res
+	case ArrayType:
+		var res lovm.Value = lovm.ConstInt(Bool.LlvmType(), 1)
+		for i := 0; i < t.Len; i++ {
+			eq := v.Equal(v.Builder.ExtractValue(x, i), v.Builder.ExtractValue(y, i), t.Elem)
+			res = v.Builder.And(res, eq)
+		}
+		return res
+	case PrimitiveType, PointerType, MapType, ChanType:
+		if t == String {
+			return v.Builder.IICmp(lovm.IntEQ, v.CompareStrings(x, y), lovm.ConstInt(Uintptr, 0))
+		}
+		return v.Builder.IICmp(lovm.IntEQ, x, y)
+	case SliceType, FunctionType:
+		// only comparisons to nil are allowed
+		return v.Builder.IICmp(lovm.IntEQ, v.Builder.ExtractValue(x, 0), v.Builder.ExtractValue(y, 0))
+	case *InterfaceType:
+		return v.InterfaceEqual(x, y)
+	}
+	util.Perrorf("invalid operation: %v cannot be compared", typ)
+	return nil
+}
+
+// CallFunction emits a call to the function name, or through the
+// function pointer fun if not nil, checking the arguments against
+// the parameters of its type. The receiver of method calls, if
+// any, is passed first.
+func (v *ExpressionVisitor) CallFunction(name string, fun lovm.Value, ft FunctionType, recv *ExpressionVisitor, exprs []ast.Expr) {
+	var args []lovm.Value
+	if recv != nil {
+		args = append(args, recv.Value)
+	}
+	args = append(args, v.Arguments(name, ft, exprs)...)
+
+	llvmType := ft.FuncType()
+	if fun != nil {
+		v.Value = v.Builder.CallIndirect(llvmType.ReturnType, fun, args...)
+	} else {
+		v.Value = v.Builder.Call(llvmType.ReturnType, name, args...)
+	}
+	switch len(ft.Results) {
+	case 0:
+		// can only be used as a statement
+		v.Type = Any
+	case 1:
+		v.Type = ft.Results[0].Type
+	default:
+		v.Type = TupleType{SymbolTypes(ft.Results)}
+	}
+}
+
+// Arguments evaluates the arguments of a call to the function
+// name, checking them against the parameters of its type.
+func (v *BlockVisitor) Arguments(name string, ft FunctionType, exprs []ast.Expr) []lovm.Value {
+	var evs []*ExpressionVisitor
+	have := len(exprs)
+	if len(exprs) == 1 && len(ft.Params) > 1 {
+		// g(f()), where f returns as many values as g takes
+		ev := v.EvaluateMultiValue(Any, exprs[0])
+		if _, ok := ev.Type.(TupleType); ok {
+			evs = v.Values(ev)
+			have = len(evs)
+		}
+	} else if len(exprs) == len(ft.Params) {
+		for i, e := range exprs {
+			evs = append(evs, v.Evaluate(ft.Params[i].Type, e))
+		}
+	}
+	if len(evs) != len(ft.Params) {
+		util.Perrorf("wrong number of arguments in call to %s: have %d, want %d", name, have, len(ft.Params))
+	}
+
+	var args []lovm.Value
+	for i, ev := range evs {
+		param := ft.Params[i]
+		if !ev.AssignableTo(param.Type) {
+			util.Perrorf("cannot use %v as %v value in argument to %s", ev.Type, param.Type, name)
+		}
+		args = append(args, ev.Value)
+	}
+	return args
+}
+
+// CommaOk evaluates the special forms yielding an additional
+// untyped boolean when assigned to two values, as in v, ok = m[k].
+// It returns false if the expression is not one of them.
+func (v *BlockVisitor) CommaOk(exp ast.Expr) ([]*ExpressionVisitor, bool) {
+	switch e := ast.Unparen(exp).(type) {
+	case *ast.IndexExpr:
+		return v.MapIndexOk(e), true
+	case *ast.TypeAssertExpr:
+		return v.TypeAssertOk(e), true
+	case *ast.UnaryExpr:
+		if e.Op == token.ARROW {
+			return v.ReceiveOk(e), true
+		}
+	}
+	return nil, false
+}
+
+// Unpack evaluates a multi-valued expression (a call to a function
+// with multiple results) and returns one result per value.
+func (v *BlockVisitor) Unpack(exp ast.Expr) []*ExpressionVisitor {
+	ev := v.EvaluateMultiValue(Any, exp)
+	if _, ok := ev.Type.(TupleType); !ok {
+		util.Perrorf("%v is not a multi-valued expression", ev.Type)
+	}
+	return v.Values(ev)
+}
+
+// Values returns the values of the multi-valued expression ev.
+func (v *BlockVisitor) Values(ev *ExpressionVisitor) []*ExpressionVisitor {
+	tuple := ev.Type.(TupleType)
+	res := make([]*ExpressionVisitor, len(tuple.Types))
+	for i, t := range tuple.Types {
+		res[i] = &ExpressionVisitor{v, v.Builder.ExtractValue(ev.Value, i), t}
+	}
+	return res
+}
+
 func (v *ExpressionVisitor) Evaluate(exp ast.Expr) *ExpressionVisitor {
 	ev := *v
 	Walk(&ev, exp)
+	ev.SingleValue(exp)
 	return &ev
 }
 
 func (v *BlockVisitor) Evaluate(typ Type, exp ast.Expr) *ExpressionVisitor {
+	ev := v.EvaluateMultiValue(typ, exp)
+	ev.SingleValue(exp)
+	return ev
+}
+
+// EvaluateMultiValue evaluates an expression which
+// may be a call to a function with multiple results.
+func (v *BlockVisitor) EvaluateMultiValue(typ Type, exp ast.Expr) *ExpressionVisitor {
 	ev := &ExpressionVisitor{v, nil, typ}
 	Walk(ev, exp)
 	return ev
 }
 
+// SingleValue checks that exp, whose value is the one
+// of the visitor, is not multi-valued.
+func (v *ExpressionVisitor) SingleValue(exp ast.Expr) {
+	if _, ok := v.Type.(TupleType); ok {
+		util.Perrorf("multiple-value %s in single-value context", types.ExprString(exp))
+	}
+}
+
 func (v *BlockVisitor) EvaluateBlock(exp ast.Stmt) *BlockVisitor {
 	newScope := NewScope(&v.Scope)
-	bv := &BlockVisitor{newScope, v.FunctionVisitor, &lovm.Block{}}
-	Walk(SkipRoot{bv}, exp)
+	bv := &BlockVisitor{newScope, v.FunctionVisitor}
+	if _, ok := exp.(*ast.BlockStmt); ok {
+		Walk(SkipRoot{bv}, exp)
+	} else {
+		// e.g. the "if" in "else if"
+		Walk(bv, exp)
+	}
 	return bv
 }
 
+// NewDeadBlock moves the insertion point to a fresh block
+// without predecessors. Statements following a return or
+// a branch are emitted there.
+func (v *FunctionVisitor) NewDeadBlock() {
+	dead := v.Function.NewBlock()
+	dead.Seal()
+	v.Builder.SetInsertionPoint(dead)
+}
+
+func (v *FunctionVisitor) PushTarget(target BranchTarget) {
+	v.Targets = append(v.Targets, target)
+}
+
+func (v *FunctionVisitor) PopTarget() {
+	v.Targets = v.Targets[:len(v.Targets)-1]
+}
+
+// FindTarget returns the block a break or continue
+// statement with an optional label jumps to.
+func (v *FunctionVisitor) FindTarget(tok token.Token, label *ast.Ident) *lovm.Block {
+	for i := len(v.Targets) - 1; i >= 0; i-- {
+		t := v.Targets[i]
+		if label != nil && t.Label != label.Name {
+			continue
+		}
+		switch tok {
+		case token.BREAK:
+			return t.Break
+		case token.CONTINUE:
+			if t.Continue != nil {
+				return t.Continue
+			}
+			if label != nil {
+				util.Perrorf("invalid continue label %s", label.Name)
+			}
+		}
+	}
+	if label != nil {
+		util.Perrorf("%s label not defined: %s", tok, label.Name)
+	}
+	util.Perrorf("%s is not in a loop", tok)
+	return nil
+}
+
+// EscapingLoopVars returns the variables declared by the init
+// statement of a for loop which live in memory.
+func (v *BlockVisitor) EscapingLoopVars(init ast.Stmt) []Symbol {
+	var res []Symbol
+	if a, ok := init.(*ast.AssignStmt); ok && a.Tok == token.DEFINE {
+		for _, l := range a.Lhs {
+			if sym, ok := v.Symbols[l.(*ast.Ident).Name]; ok && sym.Address != nil {
+				res = append(res, sym)
+			}
+		}
+	}
+	return res
+}
+
+// CompileFor lowers a for statement to:
+//
+//	  init
+//	  br header
+//	header:
+//	  br cond, body, exit
+//	body:
+//	  ...
+//	  br post
+//	post:
+//	  post
+//	  br header
+//	exit:
+//
+// The header is sealed only after the back edge from post
+// is known, so variables updated in the loop get a phi there.
+// Loop variables living in memory are copied to a fresh cell at
+// the top of the body, and copied back at the start of post.
+func (v *BlockVisitor) CompileFor(n *ast.ForStmt, label string) {
+	lv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+	if n.Init != nil {
+		Walk(lv, n.Init)
+	}
+
+	header := v.Function.NewBlock()
+	body := v.Function.NewBlock()
+	post := v.Function.NewBlock()
+	exit := v.Function.NewBlock()
+
+	v.Builder.Branch(header)
+	v.Builder.SetInsertionPoint(header)
+	if n.Cond != nil {
+		cond := lv.Evaluate(Bool, n.Cond)
+		v.Builder.BranchIf(cond.Value, body, exit)
+	} else {
+		v.Builder.Branch(body)
+	}
+	body.Seal()
+
+	// each iteration has its own copy of the loop variables
+	// living in memory, which closures may capture
+	v.Builder.SetInsertionPoint(body)
+	bv := &BlockVisitor{NewScope(&lv.Scope), v.FunctionVisitor}
+	vars := lv.EscapingLoopVars(n.Init)
+	copies := make([]Symbol, len(vars))
+	for i, sym := range vars {
+		copies[i] = Symbol{Name: sym.Name, Type: sym.Type, Id: v.VarSequence.Next(), Address: v.Alloc(sym.Type)}
+		if err := bv.AddVar(copies[i]); err != nil {
+			util.Perrorf("cannot add var %s: %s", sym.Name, err)
+		}
+		bv.WriteVar(copies[i], lv.ReadVar(sym))
+	}
+	v.PushTarget(BranchTarget{label, exit, post})
+	bv.EvaluateBlock(n.Body)
+	v.PopTarget()
+	v.Builder.Branch(post)
+	post.Seal()
+
+	v.Builder.SetInsertionPoint(post)
+	for i, sym := range vars {
+		lv.WriteVar(sym, bv.ReadVar(copies[i]))
+	}
+	if n.Post != nil {
+		Walk(lv, n.Post)
+	}
+	v.Builder.Branch(header)
+	header.Seal()
+
+	exit.Seal()
+	v.Builder.SetInsertionPoint(exit)
+}
+
 func (v *BlockVisitor) Visit(node ast.Node) ast.Visitor {
 	if node != nil {
 		switch n := node.(type) {
 		case *ast.ReturnStmt:
 			functionReturnSymbols := v.FunctionType.Results
-			if len(functionReturnSymbols) != len(n.Results) {
+
+			var results []*ExpressionVisitor
+			if len(n.Results) == 0 && len(functionReturnSymbols) > 0 && functionReturnSymbols[0].Name != "" {
+				// bare return of the named results
+				for _, sym := range functionReturnSymbols {
+					results = append(results, &ExpressionVisitor{v, v.ReadVar(sym), sym.Type})
+				}
+			} else if len(n.Results) == 1 && len(functionReturnSymbols) > 1 {
+				// return f()
+				results = v.Unpack(n.Results[0])
+			} else {
+				for i, e := range n.Results {
+					var typ Type = Any
+					if i < len(functionReturnSymbols) {
+						typ = functionReturnSymbols[i].Type
+					}
+					results = append(results, v.Evaluate(typ, e))
+				}
+			}
+			if len(functionReturnSymbols) != len(results) {
 				util.Perrorf("too many/too few arguments to return")
 			}
 
-			values := make([]lovm.Value, len(n.Results))
-			types := make([]lovm.Type, len(n.Results))
-
-			for i, e := range n.Results {
-				ev := &ExpressionVisitor{v, nil, functionReturnSymbols[i].Type}
-				Walk(ev, e)
+			values := make([]lovm.Value, len(results))
+			for i, ev := range results {
+				if !ev.AssignableTo(functionReturnSymbols[i].Type) {
+					util.Perrorf("cannot use %v as %v value in return statement", ev.Type, functionReturnSymbols[i].Type)
+				}
 				values[i] = ev.Value
-				types[i] = ev.Type.LlvmType()
 			}
-
-			var res lovm.Value
-			switch len(values) {
-			case 1:
-				res = values[0]
-			default:
-				util.Perrorf("unimplemented multiple return values")
-			}
-			v.Builder.Return(res)
+			v.Return(values)
+			v.NewDeadBlock()
+		case *ast.DeferStmt:
+			v.Defer(n.Call)
+		case *ast.GoStmt:
+			v.Go(n.Call)
+		case *ast.SendStmt:
+			v.Send(n)
 		case *ast.ExprStmt:
 			ev := &ExpressionVisitor{v, nil, Any}
 			Walk(ev, n.X)
@@ -411,51 +1299,106 @@ func (v *BlockVisitor) Visit(node ast.Node) ast.Visitor {
 			if err != nil {
 				log.Fatal("syntax error:", err)
 			}
+		case *ast.IncDecStmt:
+			op := token.ADD
+			if n.Tok == token.DEC {
+				op = token.SUB
+			}
+			one := &ast.BasicLit{ValuePos: n.TokPos, Kind: token.INT, Value: "1"}
+			v.UpdateAssign(n.X, op, one)
 		case *ast.AssignStmt:
 			if n.Tok == token.DEFINE {
-				util.Perrorf("NOT IMPLEMENTED YET: type inference in var decl")
-			} else {
-				if len(n.Lhs) != len(n.Rhs) {
-					util.Perrorf("too many/too few expressions in assignment")
+				v.Define(n)
+			} else if n.Tok != token.ASSIGN {
+				// x op= y
+				if len(n.Lhs) != 1 || len(n.Rhs) != 1 {
+					util.Perrorf("assignment operation %s requires single-valued expressions", n.Tok)
 				}
-
-				symbols := make([]Symbol, len(n.Lhs))
+				v.UpdateAssign(n.Lhs[0], n.Tok-token.ADD_ASSIGN+token.ADD, n.Rhs[0])
+			} else {
+				lvalues := make([]LValue, len(n.Lhs))
+				hints := make([]Type, len(n.Lhs))
 				for i, e := range n.Lhs {
-					symbols[i] = v.ResolveSymbol(e.(*ast.Ident).Name)
+					lvalues[i] = v.Addressable(e)
+					hints[i] = lvalues[i].Type
 				}
 
-				values := make([]lovm.Value, len(n.Lhs))
-				for i, e := range n.Rhs {
-					ev := &ExpressionVisitor{v, nil, symbols[i].Type}
-					Walk(ev, e)
-					values[i] = ev.Value
-				}
-				for i, sym := range symbols {
-					v.Block.Assign(sym, values[i])
+				results := v.EvaluateValues(hints, n.Rhs)
+				for i, lv := range lvalues {
+					if lv.Type != Any && !results[i].AssignableTo(lv.Type) {
+						util.Perrorf("cannot use %v as %v value in assignment", results[i].Type, lv.Type)
+					}
+					lv.Store(results[i].Value)
 				}
 			}
 		case *ast.IfStmt:
+			// the init statement is in an implicit block
+			// enclosing the whole if statement
+			iv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
 			if n.Init != nil {
-				// TODO(mkm) fix scoping issue
-				Walk(v, n.Init)
+				Walk(iv, n.Init)
 			}
-			cond := v.Evaluate(Bool, n.Cond)
+			cond := iv.Evaluate(Bool, n.Cond)
 			iftrue := v.Function.NewBlock()
 			iffalse := v.Function.NewBlock()
 			endif := v.Function.NewBlock()
 
 			v.Builder.BranchIf(cond.Value, iftrue, iffalse)
+			iftrue.Seal()
+			iffalse.Seal()
 
 			v.Builder.SetInsertionPoint(iftrue)
-			v.EvaluateBlock(n.Body)
+			iv.EvaluateBlock(n.Body)
 			v.Builder.Branch(endif)
 
 			v.Builder.SetInsertionPoint(iffalse)
 			if n.Else != nil {
-				v.EvaluateBlock(n.Else)
+				iv.EvaluateBlock(n.Else)
 			}
 			v.Builder.Branch(endif)
+			endif.Seal()
 			v.Builder.SetInsertionPoint(endif)
+		case *ast.ForStmt:
+			v.CompileFor(n, "")
+		case *ast.RangeStmt:
+			v.CompileRange(n, "")
+		case *ast.SwitchStmt:
+			v.CompileSwitch(n, "")
+		case *ast.TypeSwitchStmt:
+			v.CompileTypeSwitch(n, "")
+		case *ast.SelectStmt:
+			v.CompileSelect(n, "")
+		case *ast.LabeledStmt:
+			switch s := n.Stmt.(type) {
+			case *ast.ForStmt:
+				v.CompileFor(s, n.Label.Name)
+			case *ast.RangeStmt:
+				v.CompileRange(s, n.Label.Name)
+			case *ast.SwitchStmt:
+				v.CompileSwitch(s, n.Label.Name)
+			case *ast.TypeSwitchStmt:
+				v.CompileTypeSwitch(s, n.Label.Name)
+			case *ast.SelectStmt:
+				v.CompileSelect(s, n.Label.Name)
+			default:
+				// labels are only used as break/continue targets
+				Walk(v, n.Stmt)
+			}
+		case *ast.BranchStmt:
+			switch n.Tok {
+			case token.BREAK, token.CONTINUE:
+				v.Builder.Branch(v.FindTarget(n.Tok, n.Label))
+				v.NewDeadBlock()
+			case token.FALLTHROUGH:
+				// allowed only at the end of switch clauses, see switch.go
+				util.Perrorf("fallthrough statement out of place")
+			default:
+				util.Perrorf("unimplemented branch statement: %s", n.Tok)
+			}
+		case *ast.BlockStmt:
+			v.EvaluateBlock(n)
+		case *ast.EmptyStmt:
+			// nothing to do
 		default:
 			util.Perrorf("----- Block visitor: UNKNOWN %#v\n", node)
 			return v
@@ -493,7 +1436,8 @@ func CompileFile(fset *token.FileSet, tree *ast.File) error {
 	}
 
 	ctx := lovm.NewContext(f)
-	v := &ModuleVisitor{NewFileSetScope(fset, &Scope{Symbols: make(SymbolMap)}), ctx.NewModule(tree.Name.Name), "", 0}
+	universe := &Scope{Symbols: make(SymbolMap)}
+	v := &ModuleVisitor{NewFileSetScope(fset, universe), ctx.NewModule(tree.Name.Name), "", 0, map[*ast.FuncDecl]DeclaredFunction{}, nil, nil, map[string]bool{}, map[string]lovm.Value{}, map[string]bool{}, map[string]lovm.Value{}, map[string]lovm.Value{}, map[string]lovm.Value{}, map[string]lovm.Value{}}
 	// TODO(mkm) cleanup this mess
 	v.Scope.VarSequence = &v.VarSequence
 	Walk(v, tree)
@@ -503,12 +1447,12 @@ func CompileFile(fset *token.FileSet, tree *ast.File) error {
 }
 
 func OpenAndCompileFile(name string) error {
-	var fset token.FileSet
-	ast, err := parser.ParseFile(&fset, name, nil, parser.ParseComments)
+	fset := token.NewFileSet()
+	ast, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
 	if err != nil {
 		return err
 	}
-	err = CompileFile(&fset, ast)
+	err = CompileFile(fset, ast)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Package level variables are emitted as llvm globals, which
+// functions read and write through loads and stores.
+//
+// Variables initialized with constants (or not initialized) get
+// a static initializer. The others are initialized by the package
+// init function, which also calls the user defined init functions
+// and is run before main.
+//
+// As required by the Go spec, variables are initialized by repeatedly
+// selecting the earliest variable in declaration order which doesn't
+// depend on uninitialized variables, either directly or through the
+// functions and methods its initializer refers to.
+
+// DeclareGlobals declares the package level variables
+// and emits their initialization.
+func (v *ModuleVisitor) DeclareGlobals(decls []ast.Decl) {
+	var specs []*ast.ValueSpec
+	uninitialized := map[*ast.ValueSpec]bool{}
+	methods := map[string][]*ast.FuncDecl{}
+	for _, d := range decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv != nil {
+			methods[fd.Name.Name] = append(methods[fd.Name.Name], fd)
+		}
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.VAR {
+			for _, sp := range gen.Specs {
+				vs := sp.(*ast.ValueSpec)
+				specs = append(specs, vs)
+				uninitialized[vs] = true
+			}
+		}
+	}
+
+	deps := map[*ast.ValueSpec]map[*ast.ValueSpec]bool{}
+	for _, vs := range specs {
+		deps[vs] = map[*ast.ValueSpec]bool{}
+		for _, e := range vs.Values {
+			VarDependencies(e, methods, deps[vs], map[*ast.FuncDecl]bool{})
+		}
+	}
+
+	for len(uninitialized) > 0 {
+		var next *ast.ValueSpec
+		for _, vs := range specs {
+			if uninitialized[vs] && !dependsOnAny(deps[vs], uninitialized) {
+				next = vs
+				break
+			}
+		}
+		if next == nil {
+			for _, vs := range specs {
+				if uninitialized[vs] {
+					defer ReportErrors(v.Scope, vs)
+					util.Perrorf("initialization cycle involving %s", vs.Names[0].Name)
+				}
+			}
+		}
+		v.DeclareGlobal(next)
+		delete(uninitialized, next)
+	}
+}
+
+func dependsOnAny(deps, specs map[*ast.ValueSpec]bool) bool {
+	for d := range deps {
+		if specs[d] {
+			return true
+		}
+	}
+	return false
+}
+
+// VarDependencies collects the package level variables referred to
+// by node, and by the bodies of the functions and methods it refers
+// to. Methods, indexed by name, are not resolved by the parser: the
+// selector x.m refers to the method m of the type of x when it is
+// evident from the syntax, and to all the methods named m otherwise.
+func VarDependencies(node ast.Node, methods map[string][]*ast.FuncDecl, deps map[*ast.ValueSpec]bool, seen map[*ast.FuncDecl]bool) {
+	follow := func(d *ast.FuncDecl) {
+		if !seen[d] && d.Body != nil {
+			seen[d] = true
+			VarDependencies(d.Body, methods, deps, seen)
+		}
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			name := OperandTypeName(sel.X)
+			for _, d := range methods[sel.Sel.Name] {
+				if name == "" || name == ReceiverTypeName(d) {
+					follow(d)
+				}
+			}
+			return true
+		}
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Obj == nil {
+			return true
+		}
+		switch d := id.Obj.Decl.(type) {
+		case *ast.ValueSpec:
+			if id.Obj.Kind == ast.Var {
+				deps[d] = true
+			}
+		case *ast.FuncDecl:
+			follow(d)
+		}
+		return true
+	})
+}
+
+// OperandTypeName returns the name of the type of the operand
+// of a selector, or of the type of a method expression, if it is
+// evident from the syntax, as in T{}.m, (&T{}).m or (*T).m, and
+// the empty string otherwise.
+func OperandTypeName(x ast.Expr) string {
+	switch e := x.(type) {
+	case *ast.ParenExpr:
+		return OperandTypeName(e.X)
+	case *ast.StarExpr:
+		return OperandTypeName(e.X)
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			return OperandTypeName(e.X)
+		}
+	case *ast.CompositeLit:
+		if id, ok := e.Type.(*ast.Ident); ok {
+			return id.Name
+		}
+	case *ast.Ident:
+		if e.Obj != nil && e.Obj.Kind == ast.Typ {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// ReceiverTypeName returns the name of the base type
+// of the receiver of a method declaration.
+func ReceiverTypeName(d *ast.FuncDecl) string {
+	t := d.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// DeclareGlobal emits the globals of a var spec, and their
+// initialization if it cannot be done statically.
+func (v *ModuleVisitor) DeclareGlobal(vs *ast.ValueSpec) {
+	defer ReportErrors(v.Scope, vs)
+
+	var typ Type
+	if vs.Type != nil {
+		typ = v.ParseType(vs.Type)
+	}
+
+	if vs.Values == nil {
+		if typ == nil {
+			util.Perrorf("missing type or init expr")
+		}
+		for _, n := range vs.Names {
+			v.AddGlobal(n.Name, typ, ZeroValue(typ))
+		}
+		return
+	}
+
+	if static, ok := v.StaticInitializers(typ, vs); ok {
+		for i, n := range vs.Names {
+			v.AddGlobal(n.Name, static[i].Type, static[i].Value)
+		}
+		return
+	}
+
+	bv := v.InitBlock()
+	hints := make([]Type, len(vs.Names))
+	for i := range hints {
+		hints[i] = typ
+		if typ == nil {
+			hints[i] = Any
+		}
+	}
+	results := bv.EvaluateValues(hints, vs.Values)
+	for i, n := range vs.Names {
+		ev := results[i]
+		varType := typ
+		if varType == nil {
+			varType = ev.Type
+		} else if !ev.AssignableTo(varType) {
+			util.Perrorf("cannot use %v as %v value in variable declaration", ev.Type, varType)
+		}
+		if sym, ok := v.AddGlobal(n.Name, varType, ZeroValue(varType)); ok {
+			bv.WriteVar(sym, ev.Value)
+		}
+	}
+}
+
+// StaticInitializers returns the values of the globals of a spec
+// initialized with integer, boolean or string constants, the
+// latter pointing to the bytes interned by the module.
+func (v *ModuleVisitor) StaticInitializers(typ Type, vs *ast.ValueSpec) ([]*ExpressionVisitor, bool) {
+	if len(vs.Values) != len(vs.Names) {
+		return nil, false
+	}
+	if _, ok := Underlying(typ).(*InterfaceType); ok {
+		// constants are boxed at run time
+		return nil, false
+	}
+	var res []*ExpressionVisitor
+	for _, e := range vs.Values {
+		c, ok := v.ConstValue(e)
+		if !ok || (!IsInteger(c.Type) && UntypedOf(c.Type) != UntypedBool && UntypedOf(c.Type) != UntypedString) {
+			return nil, false
+		}
+		hint := typ
+		if hint == nil {
+			hint = Any
+		}
+		value, valueType := c.Emit(v.Module, hint)
+		if typ != nil && !Identical(valueType, typ) {
+			util.Perrorf("cannot use %v as %v value in variable declaration", valueType, typ)
+		}
+		res = append(res, &ExpressionVisitor{nil, value, valueType})
+	}
+	return res, true
+}
+
+// AddGlobal defines a global variable in the package scope, whose
+// llvm name is qualified by the package, as functions are, so as not
+// to clash with the symbols of the runtime and libc. It returns false
+// for the blank identifier.
+func (v *ModuleVisitor) AddGlobal(name string, typ Type, init lovm.Value) (Symbol, bool) {
+	if name == "_" {
+		return Symbol{}, false
+	}
+	if typ == Any {
+		util.Perrorf("%s declared with a value of no type", name)
+	}
+	address := v.Module.NewGlobal(fmt.Sprintf("%s.%s", v.Module.Name, name), typ.LlvmType(), lovm.ValueInitializer{Value: init})
+	sym := Symbol{Name: name, Type: typ, Id: v.VarSequence.Next(), Address: address}
+	if err := v.AddVar(sym); err != nil {
+		util.Perrorf("cannot add var %s: %s", name, err)
+	}
+	return sym, true
+}
+
+// InitBlock returns a visitor emitting code in the package
+// init function, creating the function the first time.
+func (v *ModuleVisitor) InitBlock() *BlockVisitor {
+	if v.Init == nil {
+		name := fmt.Sprintf("%s.init", v.Module.Name)
+		fun := v.Module.NewFunction(name, lovm.FunctionType(lovm.VoidType(), false))
+		v.Init = v.NewFunctionVisitor(FunctionType{}, fun, nil)
+	}
+	return &BlockVisitor{NewScope(&v.Scope), v.Init}
+}
+
+// FinishInit terminates the package init function,
+// calling the user defined init functions.
+func (v *ModuleVisitor) FinishInit() {
+	if v.Init == nil && len(v.UserInit) == 0 {
+		return
+	}
+	bv := v.InitBlock()
+	for _, name := range v.UserInit {
+		bv.Builder.Call(lovm.VoidType(), name)
+	}
+	bv.Builder.Return(nil)
+	v.Module.AddConstructor(bv.Function.Name)
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+	"sort"
+	"strings"
+)
+
+// Interface values are { itab, data } pairs. The itab, null for nil
+// interfaces, points to the type descriptor of the dynamic type of
+// the value, followed by the addresses of the methods implementing
+// those of the interface, in order. The data word holds pointers
+// directly and points to a heap copy of other values. The itabs of
+// conversions from concrete types are generated at compile time, the
+// others are looked up by the runtime, see runtime/iface.c.
+
+var (
+	// matches goal_method
+	methodEntryType = lovm.StructType([]lovm.Type{BytePtr, BytePtr}, false)
+	// matches goal_type
+	typeDescriptorType = lovm.StructType([]lovm.Type{BytePtr, Uintptr, Uintptr, lovm.PointerType(Uintptr),
+		Uintptr, lovm.PointerType(methodEntryType), Uintptr}, false)
+	// matches goal_interfacetype
+	interfaceDescriptorType = lovm.StructType([]lovm.Type{BytePtr, lovm.PointerType(BytePtr), Uintptr}, false)
+)
+
+// ResolveInterfaceType resolves an interface type, including
+// the methods of the embedded interfaces.
+func (s *Scope) ResolveInterfaceType(it *ast.InterfaceType) (Type, error) {
+	res := &InterfaceType{}
+	add := func(m IMethod, explicit bool) error {
+		for _, n := range res.Methods {
+			if n.Name == m.Name {
+				if !explicit && Identical(n.Type, m.Type) {
+					// embedded interfaces can overlap
+					return nil
+				}
+				return fmt.Errorf("duplicate method %s", m.Name)
+			}
+		}
+		res.Methods = append(res.Methods, m)
+		return nil
+	}
+	for _, f := range it.Methods.List {
+		if f.Names == nil {
+			typ, err := s.ResolveType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			embedded, ok := Underlying(typ).(*InterfaceType)
+			if !ok {
+				return nil, fmt.Errorf("interface contains type constraints")
+			}
+			for _, m := range embedded.Methods {
+				if err := add(m, false); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		sig := s.ParseFuncType(f.Type.(*ast.FuncType))
+		for _, n := range f.Names {
+			if err := add(IMethod{n.Name, sig}, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(res.Methods, func(i, j int) bool { return res.Methods[i].Name < res.Methods[j].Name })
+	return res, nil
+}
+
+// TypeString returns a type as written in Go source,
+// naming the dynamic types of interface values.
+func TypeString(t Type) string {
+	switch u := t.(type) {
+	case *NamedType:
+		if u.Package == "" {
+			return u.Name
+		}
+		return u.Package + "." + u.Name
+	case PrimitiveType:
+		return u.Name
+	case PointerType:
+		return "*" + TypeString(u.Elem)
+	case SliceType:
+		return "[]" + TypeString(u.Elem)
+	case ArrayType:
+		return fmt.Sprintf("[%d]%s", u.Len, TypeString(u.Elem))
+	case MapType:
+		return fmt.Sprintf("map[%s]%s", TypeString(u.Key), TypeString(u.Value))
+	case ChanType:
+		switch u.Dir {
+		case ast.SEND:
+			return "chan<- " + TypeString(u.Elem)
+		case ast.RECV:
+			return "<-chan " + TypeString(u.Elem)
+		}
+		if elem, ok := u.Elem.(ChanType); ok && elem.Dir == ast.RECV {
+			return "chan (" + TypeString(elem) + ")"
+		}
+		return "chan " + TypeString(u.Elem)
+	case StructType:
+		if len(u.Fields) == 0 {
+			return "struct {}"
+		}
+		fields := make([]string, len(u.Fields))
+		for i, f := range u.Fields {
+			fields[i] = TypeString(f.Type)
+			if !f.Embedded {
+				fields[i] = f.Name + " " + fields[i]
+			}
+		}
+		return fmt.Sprintf("struct { %s }", strings.Join(fields, "; "))
+	case *InterfaceType:
+		if len(u.Methods) == 0 {
+			return "interface {}"
+		}
+		methods := make([]string, len(u.Methods))
+		for i, m := range u.Methods {
+			methods[i] = m.Name + Signature(m.Type)
+		}
+		return fmt.Sprintf("interface { %s }", strings.Join(methods, "; "))
+	case FunctionType:
+		return "func" + Signature(u)
+	}
+	return fmt.Sprint(t)
+}
+
+// Signature returns the parameters and results of a function
+// type as written in Go source, as in (int, string) error.
+func Signature(ft FunctionType) string {
+	params := make([]string, len(ft.Params))
+	for i, p := range ft.Params {
+		params[i] = TypeString(p.Type)
+	}
+	results := make([]string, len(ft.Results))
+	for i, r := range ft.Results {
+		results[i] = TypeString(r.Type)
+	}
+	res := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+		return res
+	case 1:
+		return res + " " + results[0]
+	}
+	return res + " (" + strings.Join(results, ", ") + ")"
+}
+
+// MethodSet returns the methods of values of type t, sorted by
+// name. The method set of *T includes the methods of T.
+func MethodSet(t Type) (res []*Method) {
+	pointer := false
+	if ptr, ok := t.(PointerType); ok {
+		t, pointer = ptr.Elem, true
+	}
+	named, ok := t.(*NamedType)
+	if !ok {
+		return nil
+	}
+	for _, m := range named.Methods {
+		if pointer || !m.PointerRecv {
+			res = append(res, m)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// MissingMethod returns the reason why values of type t don't
+// implement the interface it, or "" if they do.
+func MissingMethod(t Type, it *InterfaceType) string {
+	if other, ok := Underlying(t).(*InterfaceType); ok {
+		for _, m := range it.Methods {
+			i := other.MethodIndex(m.Name)
+			if i < 0 {
+				return fmt.Sprintf("missing method %s", m.Name)
+			}
+			if !Identical(other.Methods[i].Type, m.Type) {
+				return fmt.Sprintf("wrong type for method %s", m.Name)
+			}
+		}
+		return ""
+	}
+	for _, m := range it.Methods {
+		method, ok := LookupMethod(t, m.Name)
+		if !ok {
+			return fmt.Sprintf("missing method %s", m.Name)
+		}
+		if _, ok := t.(PointerType); !ok && method.PointerRecv {
+			return fmt.Sprintf("method %s has pointer receiver", m.Name)
+		}
+		if !Identical(method.Type, m.Type) {
+			return fmt.Sprintf("wrong type for method %s", m.Name)
+		}
+	}
+	return ""
+}
+
+// CString returns a constant pointer to s followed by a NUL byte.
+func (v *ModuleVisitor) CString(s string) lovm.Value {
+	return lovm.ConstGEP(v.Module.ConstString(s), lovm.Indices(0, 0)...)
+}
+
+// MethodWrapper returns the address of a function calling the method
+// m of values of type t, which takes the data word of an interface
+// value holding a t as its receiver.
+func (v *ModuleVisitor) MethodWrapper(m *Method, t Type) lovm.Value {
+	if m.wrapper != nil {
+		return m.wrapper
+	}
+	if ptr, ok := t.(PointerType); ok {
+		t = ptr.Elem
+	}
+	llvmType := ClosureType(m.Type)
+	fun := v.Module.NewFunction(m.Symbol+".i", llvmType)
+	builder := fun.NewBuilder()
+	entry := fun.NewBlock()
+	entry.Seal()
+	builder.SetInsertionPoint(entry)
+
+	recv := builder.BitCast(fun.Param(0), lovm.PointerType(t.LlvmType()))
+	if !m.PointerRecv {
+		recv = builder.Load(recv)
+	}
+	args := []lovm.Value{recv}
+	for i := range m.Type.Params {
+		args = append(args, fun.Param(i+1))
+	}
+	res := builder.Call(llvmType.ReturnType, m.Symbol, args...)
+	if len(m.Type.Results) == 0 {
+		builder.Return(nil)
+	} else {
+		builder.Return(res)
+	}
+
+	m.wrapper = lovm.ConstBitCast(fun.Ref(), BytePtr)
+	return m.wrapper
+}
+
+// TypeDescriptor returns the address of the descriptor of the
+// dynamic type t, as a goal_type.
+func (v *ModuleVisitor) TypeDescriptor(t Type) lovm.Value {
+	name := TypeString(t)
+	if desc, ok := v.TypeDescriptors[name]; ok {
+		return desc
+	}
+	global := fmt.Sprintf(".type%d", len(v.TypeDescriptors))
+
+	direct := 0
+	if _, ok := t.(PointerType); ok {
+		direct = 1
+	}
+	var fields lovm.Value = lovm.ConstNull(lovm.PointerType(Uintptr))
+	nfields := -1
+	if Comparable(t) {
+		fields, nfields = v.KeyDescriptor(t)
+	}
+
+	var methods lovm.Value = lovm.ConstNull(lovm.PointerType(methodEntryType))
+	set := MethodSet(t)
+	if len(set) > 0 {
+		entries := make([]lovm.Value, len(set))
+		for i, m := range set {
+			entries[i] = lovm.ConstStruct(methodEntryType, v.CString(m.Name+Signature(m.Type)), v.MethodWrapper(m, t))
+		}
+		init := lovm.ConstArray(methodEntryType, entries...)
+		table := v.Module.NewGlobal(global+".methods", init.Type(), lovm.ValueInitializer{Value: init})
+		methods = lovm.ConstGEP(table, lovm.Indices(0, 0)...)
+	}
+
+	init := lovm.ConstStruct(typeDescriptorType, v.CString(name), lovm.ConstInt(Uintptr, int64(Sizeof(t))),
+		lovm.ConstInt(Uintptr, int64(direct)), fields, lovm.ConstInt(Uintptr, int64(nfields)),
+		methods, lovm.ConstInt(Uintptr, int64(len(set))))
+	desc := lovm.ConstBitCast(v.Module.NewGlobal(global, typeDescriptorType, lovm.ValueInitializer{Value: init}), BytePtr)
+	v.TypeDescriptors[name] = desc
+	return desc
+}
+
+// InterfaceDescriptor returns the address of the descriptor
+// of the interface type t, as a goal_interfacetype.
+func (v *ModuleVisitor) InterfaceDescriptor(t Type) lovm.Value {
+	name := TypeString(t)
+	if desc, ok := v.InterfaceDescriptors[name]; ok {
+		return desc
+	}
+	global := fmt.Sprintf(".interface%d", len(v.InterfaceDescriptors))
+
+	it := Underlying(t).(*InterfaceType)
+	var methods lovm.Value = lovm.ConstNull(lovm.PointerType(BytePtr))
+	if len(it.Methods) > 0 {
+		keys := make([]lovm.Value, len(it.Methods))
+		for i, m := range it.Methods {
+			keys[i] = v.CString(m.Name + Signature(m.Type))
+		}
+		init := lovm.ConstArray(BytePtr, keys...)
+		table := v.Module.NewGlobal(global+".methods", init.Type(), lovm.ValueInitializer{Value: init})
+		methods = lovm.ConstGEP(table, lovm.Indices(0, 0)...)
+	}
+
+	init := lovm.ConstStruct(interfaceDescriptorType, v.CString(name), methods, lovm.ConstInt(Uintptr, int64(len(it.Methods))))
+	desc := lovm.ConstBitCast(v.Module.NewGlobal(global, interfaceDescriptorType, lovm.ValueInitializer{Value: init}), BytePtr)
+	v.InterfaceDescriptors[name] = desc
+	return desc
+}
+
+// Itab returns the address of the itab of the concrete type t
+// for the interface type iface, which t must implement.
+func (v *ModuleVisitor) Itab(t, iface Type) lovm.Value {
+	key := TypeString(t) + " " + TypeString(iface)
+	if itab, ok := v.Itabs[key]; ok {
+		return itab
+	}
+	it := Underlying(iface).(*InterfaceType)
+	types := []lovm.Type{BytePtr}
+	fields := []lovm.Value{v.TypeDescriptor(t)}
+	for _, im := range it.Methods {
+		m, _ := LookupMethod(t, im.Name)
+		types = append(types, BytePtr)
+		fields = append(fields, v.MethodWrapper(m, t))
+	}
+	typ := lovm.StructType(types, false)
+	global := v.Module.NewGlobal(fmt.Sprintf(".itab%d", len(v.Itabs)), typ, lovm.ValueInitializer{Value: lovm.ConstStruct(typ, fields...)})
+	itab := lovm.ConstBitCast(global, BytePtr)
+	v.Itabs[key] = itab
+	return itab
+}
+
+// Interface builds an interface value of type t.
+func (v *BlockVisitor) Interface(t Type, itab, data lovm.Value) lovm.Value {
+	res := v.Builder.InsertValue(lovm.Undef(t.LlvmType()), itab, 0)
+	return v.Builder.InsertValue(res, data, 1)
+}
+
+// Box returns the data word of an interface value holding value.
+func (v *BlockVisitor) Box(value lovm.Value, t Type) lovm.Value {
+	if _, ok := t.(PointerType); ok {
+		return v.Builder.BitCast(value, BytePtr)
+	}
+	ptr := v.Alloc(t)
+	v.Builder.Store(value, ptr)
+	return v.Builder.BitCast(ptr, BytePtr)
+}
+
+// Unbox returns the value of type t held by an interface value.
+func (v *BlockVisitor) Unbox(data lovm.Value, t Type) lovm.Value {
+	if _, ok := t.(PointerType); ok {
+		return v.Builder.BitCast(data, t.LlvmType())
+	}
+	return v.Builder.Load(v.Builder.BitCast(data, lovm.PointerType(t.LlvmType())))
+}
+
+// DynamicType returns the type descriptor of the dynamic
+// type of an interface value, null for nil interfaces.
+func (v *BlockVisitor) DynamicType(x lovm.Value) lovm.Value {
+	itabtype := v.DeclareRuntime("goal_itabtype", BytePtr, BytePtr)
+	return v.Builder.Call(BytePtr, itabtype, v.Builder.ExtractValue(x, 0))
+}
+
+// GetItab looks up the itab of the dynamic type dyn for the interface
+// type t, which is null if dyn is null or doesn't implement t. If
+// canfail is false, the runtime panics instead of returning null.
+func (v *BlockVisitor) GetItab(t Type, dyn lovm.Value, canfail bool) lovm.Value {
+	getitab := v.DeclareRuntime("goal_getitab", BytePtr, BytePtr, BytePtr, Uintptr)
+	flag := int64(0)
+	if canfail {
+		flag = 1
+	}
+	return v.Builder.Call(BytePtr, getitab, v.InterfaceDescriptor(t), dyn, lovm.ConstInt(Uintptr, flag))
+}
+
+// ToInterface converts x to the interface type t, which it implements.
+func (v *BlockVisitor) ToInterface(x *ExpressionVisitor, t Type) lovm.Value {
+	if _, ok := Underlying(x.Type).(*InterfaceType); ok {
+		// the itab depends on the dynamic type
+		itab := v.GetItab(t, v.DynamicType(x.Value), true)
+		return v.Interface(t, itab, v.Builder.ExtractValue(x.Value, 1))
+	}
+	return v.Interface(t, v.Itab(x.Type, t), v.Box(x.Value, x.Type))
+}
+
+// AssignableTo returns true if the value can be assigned to a variable
+// of type typ, converting it first if typ is an interface type.
+func (v *ExpressionVisitor) AssignableTo(typ Type) bool {
+	if Identical(v.Type, typ) {
+		return true
+	}
+	if (!IsNamed(v.Type) || !IsNamed(typ)) && Identical(Underlying(v.Type), Underlying(typ)) {
+		v.Type = typ
+		return true
+	}
+	if x, ok := Underlying(v.Type).(ChanType); ok && x.Dir == ast.SEND|ast.RECV {
+		// bidirectional channels can be used as directional ones
+		if y, ok := Underlying(typ).(ChanType); ok && (!IsNamed(v.Type) || !IsNamed(typ)) && Identical(x.Elem, y.Elem) {
+			v.Type = typ
+			return true
+		}
+	}
+	it, ok := Underlying(typ).(*InterfaceType)
+	if !ok || v.Type == Any {
+		return false
+	}
+	if _, ok := v.Type.(TupleType); ok || MissingMethod(v.Type, it) != "" {
+		return false
+	}
+	v.Value = v.ToInterface(v, typ)
+	v.Type = typ
+	return true
+}
+
+// IsNamed returns true for the named and predeclared types.
+func IsNamed(t Type) bool {
+	switch t.(type) {
+	case *NamedType, PrimitiveType:
+		return true
+	}
+	return false
+}
+
+// InterfaceEqual compares two interface values, which are equal if
+// both are nil, or have identical dynamic types and equal values.
+func (v *BlockVisitor) InterfaceEqual(x, y lovm.Value) lovm.Value {
+	ifaceeq := v.DeclareRuntime("goal_ifaceeq", Uintptr, BytePtr, BytePtr, BytePtr, BytePtr)
+	eq := v.Builder.Call(Uintptr, ifaceeq, v.Builder.ExtractValue(x, 0), v.Builder.ExtractValue(x, 1),
+		v.Builder.ExtractValue(y, 0), v.Builder.ExtractValue(y, 1))
+	return v.Builder.IICmp(lovm.IntNE, eq, lovm.ConstInt(Uintptr, 0))
+}
+
+// CallInterface evaluates the call x.name(args) of a method of
+// an interface value, through its itab.
+func (v *ExpressionVisitor) CallInterface(x *ExpressionVisitor, name string, args []ast.Expr) {
+	it := Underlying(x.Type).(*InterfaceType)
+	i := it.MethodIndex(name)
+	if i < 0 {
+		util.Perrorf("%v has no field or method %s", x.Type, name)
+	}
+	m := it.Methods[i]
+	fun := v.Builder.BitCast(v.ItabFunc(x.Value, i), lovm.PointerType(ClosureType(m.Type)))
+	data := &ExpressionVisitor{v.BlockVisitor, v.Builder.ExtractValue(x.Value, 1), PointerType{Uint8}}
+	v.CallFunction(name, fun, m.Type, data, args)
+}
+
+// ItabFunc returns the address of the function implementing
+// the i-th method of the interface value x, as an i8*.
+func (v *BlockVisitor) ItabFunc(x lovm.Value, i int) lovm.Value {
+	itab := v.Builder.BitCast(v.Builder.ExtractValue(x, 0), lovm.PointerType(BytePtr))
+	return v.Builder.Load(v.Builder.GEP(itab, lovm.ConstInt(Int64.LlvmType(), int64(i+1))))
+}
+
+// AssertionOperand evaluates the operand of a type assertion,
+// which must be an interface, and the asserted type.
+func (v *BlockVisitor) AssertionOperand(n *ast.TypeAssertExpr) (*ExpressionVisitor, Type) {
+	if n.Type == nil {
+		util.Perrorf("use of .(type) outside type switch")
+	}
+	x := v.Evaluate(Any, n.X)
+	if _, ok := Underlying(x.Type).(*InterfaceType); !ok {
+		util.Perrorf("invalid type assertion: %s (non-interface type %v on left)", types.ExprString(n), x.Type)
+	}
+	typ := v.ParseType(n.Type)
+	v.CheckAssertion(x.Type, typ)
+	return x, typ
+}
+
+// CheckAssertion reports an error if a value of the
+// interface type iface can never have dynamic type typ.
+func (v *BlockVisitor) CheckAssertion(iface, typ Type) {
+	if _, ok := Underlying(typ).(*InterfaceType); ok {
+		return
+	}
+	if reason := MissingMethod(typ, Underlying(iface).(*InterfaceType)); reason != "" {
+		util.Perrorf("impossible type assertion: %v does not implement %v (%s)", typ, iface, reason)
+	}
+}
+
+// AssertType evaluates x.(typ), returning the value and whether the
+// assertion holds. If commaOk is false, failed assertions panic,
+// otherwise they yield the zero value of typ.
+func (v *BlockVisitor) AssertType(x *ExpressionVisitor, typ Type, commaOk bool) (value, ok lovm.Value) {
+	dyn := v.DynamicType(x.Value)
+	if _, isInterface := Underlying(typ).(*InterfaceType); isInterface {
+		itab := v.GetItab(typ, dyn, commaOk)
+		value = v.Interface(typ, itab, v.Builder.ExtractValue(x.Value, 1))
+		if !commaOk {
+			return value, nil
+		}
+		ok = v.Builder.IICmp(lovm.IntNE, itab, lovm.ConstNull(BytePtr))
+		return v.ValueIf(ok, typ, value), ok
+	}
+
+	ok = v.Builder.IICmp(lovm.IntEQ, dyn, v.TypeDescriptor(typ))
+	hit := v.Function.NewBlock()
+	miss := v.Function.NewBlock()
+	v.Builder.BranchIf(ok, hit, miss)
+	hit.Seal()
+	miss.Seal()
+
+	result := Symbol{Type: typ, Id: v.VarSequence.Next()}
+	v.Builder.SetInsertionPoint(miss)
+	if commaOk {
+		v.WriteVar(result, ZeroValue(typ))
+	} else {
+		panicassert := v.DeclareRuntime("goal_panicassert", lovm.VoidType(), BytePtr, BytePtr, BytePtr)
+		v.Builder.Call(lovm.VoidType(), panicassert, dyn, v.TypeDescriptor(typ), v.CString(TypeString(x.Type)))
+		v.Builder.Unreachable()
+	}
+
+	done := v.Function.NewBlock()
+	v.Builder.SetInsertionPoint(hit)
+	v.WriteVar(result, v.Unbox(v.Builder.ExtractValue(x.Value, 1), typ))
+	v.Builder.Branch(done)
+	if commaOk {
+		v.Builder.SetInsertionPoint(miss)
+		v.Builder.Branch(done)
+	}
+	done.Seal()
+	v.Builder.SetInsertionPoint(done)
+	return v.ReadVar(result), ok
+}
+
+// ValueIf returns value if cond is true, and the zero value of typ otherwise.
+func (v *BlockVisitor) ValueIf(cond lovm.Value, typ Type, value lovm.Value) lovm.Value {
+	result := Symbol{Type: typ, Id: v.VarSequence.Next()}
+	v.WriteVar(result, ZeroValue(typ))
+	then := v.Function.NewBlock()
+	done := v.Function.NewBlock()
+	v.Builder.BranchIf(cond, then, done)
+	then.Seal()
+	v.Builder.SetInsertionPoint(then)
+	v.WriteVar(result, value)
+	v.Builder.Branch(done)
+	done.Seal()
+	v.Builder.SetInsertionPoint(done)
+	return v.ReadVar(result)
+}
+
+// TypeAssertOk evaluates x.(T) in the comma-ok form.
+func (v *BlockVisitor) TypeAssertOk(n *ast.TypeAssertExpr) []*ExpressionVisitor {
+	x, typ := v.AssertionOperand(n)
+	value, ok := v.AssertType(x, typ, true)
+	return []*ExpressionVisitor{{v, value, typ}, {v, ok, Bool}}
+}
+
+// CompileTypeSwitch compiles a type switch. The cases are tested in
+// order, and the variable declared by the switch guard, if any, has the
+// type of the case in clauses listing a single type, and the type of the
+// operand otherwise.
+func (v *BlockVisitor) CompileTypeSwitch(n *ast.TypeSwitchStmt, label string) {
+	sv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+	if n.Init != nil {
+		Walk(sv, n.Init)
+	}
+	var bound *ast.Ident
+	var guard ast.Expr
+	switch s := n.Assign.(type) {
+	case *ast.AssignStmt:
+		bound, guard = s.Lhs[0].(*ast.Ident), s.Rhs[0]
+	case *ast.ExprStmt:
+		guard = s.X
+	}
+	ta := ast.Unparen(guard).(*ast.TypeAssertExpr)
+	x := sv.Evaluate(Any, ta.X)
+	if _, ok := Underlying(x.Type).(*InterfaceType); !ok {
+		util.Perrorf("%s (type %v) is not an interface", types.ExprString(ta.X), x.Type)
+	}
+	dyn := sv.DynamicType(x.Value)
+
+	exit := v.Function.NewBlock()
+	v.PushTarget(BranchTarget{label, exit, nil})
+	var def *ast.CaseClause
+	seen := map[string]bool{}
+	for _, s := range n.Body.List {
+		cc := s.(*ast.CaseClause)
+		if cc.List == nil {
+			def = cc
+			continue
+		}
+
+		body := v.Function.NewBlock()
+		typ, value := x.Type, x.Value
+		for _, e := range cc.List {
+			var cond lovm.Value
+			key := "nil"
+			if sv.IsNil(e) {
+				cond = v.Builder.IICmp(lovm.IntEQ, dyn, lovm.ConstNull(BytePtr))
+			} else {
+				typ = sv.ParseType(e)
+				key = TypeString(typ)
+				sv.CheckAssertion(x.Type, typ)
+				if _, ok := Underlying(typ).(*InterfaceType); ok {
+					itab := sv.GetItab(typ, dyn, true)
+					cond = v.Builder.IICmp(lovm.IntNE, itab, lovm.ConstNull(BytePtr))
+					value = sv.Interface(typ, itab, v.Builder.ExtractValue(x.Value, 1))
+				} else {
+					cond = v.Builder.IICmp(lovm.IntEQ, dyn, sv.TypeDescriptor(typ))
+					value = nil
+				}
+			}
+			if seen[key] {
+				util.Perrorf("duplicate case %s in type switch", types.ExprString(e))
+			}
+			seen[key] = true
+
+			next := v.Function.NewBlock()
+			v.Builder.BranchIf(cond, body, next)
+			next.Seal()
+			v.Builder.SetInsertionPoint(next)
+		}
+		if len(cc.List) > 1 || sv.IsNil(cc.List[0]) {
+			typ, value = x.Type, x.Value
+		}
+		test := v.Builder.GetInsertBlock()
+		body.Seal()
+		v.Builder.SetInsertionPoint(body)
+		if value == nil {
+			value = sv.Unbox(v.Builder.ExtractValue(x.Value, 1), typ)
+		}
+		sv.CompileCaseClause(cc, bound, typ, value)
+		v.Builder.Branch(exit)
+		v.Builder.SetInsertionPoint(test)
+	}
+	if def != nil {
+		sv.CompileCaseClause(def, bound, x.Type, x.Value)
+	}
+	v.Builder.Branch(exit)
+	v.PopTarget()
+	exit.Seal()
+	v.Builder.SetInsertionPoint(exit)
+}
+
+// CompileCaseClause compiles the body of a clause of a type switch,
+// declaring the variable of the switch guard, if any.
+func (v *BlockVisitor) CompileCaseClause(cc *ast.CaseClause, bound *ast.Ident, typ Type, value lovm.Value) {
+	cv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+	if bound != nil && bound.Name != "_" {
+		cv.DeclareVar(bound, typ, value)
+	}
+	for _, s := range cc.Body {
+		Walk(cv, s)
+	}
+}
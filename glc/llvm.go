@@ -16,6 +16,13 @@ func SymbolsToLlvmTypes(ss []Symbol) (res []lovm.Type) {
 	return
 }
 
+func TypesToLlvmTypes(ts []Type) (res []lovm.Type) {
+	for _, t := range ts {
+		res = append(res, t.LlvmType())
+	}
+	return
+}
+
 func (s *Scope) ParseLlvmTypes(fl *ast.FieldList) (res []lovm.Type) {
 	if fl == nil {
 		return nil
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Maps are lowered to pointers to hash tables allocated by the
+// runtime, null for nil maps. Keys and values are passed to the
+// runtime by address.
+
+// KeyFields appends to fields the pairs of offset and size of the
+// parts of a key of type t, at offset in the key, which the runtime
+// hashes and compares. Strings have size 0, since their contents are
+// compared, interfaces have size -1, and padding and blank fields
+// are left out.
+func KeyFields(t Type, offset int, fields []int64) []int64 {
+	switch u := Underlying(t).(type) {
+	case StructType:
+		off := 0
+		for _, f := range u.Fields {
+			off = alignTo(off, Alignof(f.Type))
+			if f.Name != "_" {
+				fields = KeyFields(f.Type, offset+off, fields)
+			}
+			off += Sizeof(f.Type)
+		}
+		return fields
+	case ArrayType:
+		for i := 0; i < u.Len; i++ {
+			fields = KeyFields(u.Elem, offset+i*Sizeof(u.Elem), fields)
+		}
+		return fields
+	}
+	if Underlying(t) == String {
+		return append(fields, int64(offset), 0)
+	}
+	if _, ok := Underlying(t).(*InterfaceType); ok {
+		return append(fields, int64(offset), -1)
+	}
+	size := int64(Sizeof(t))
+	if n := len(fields); n > 0 && fields[n-1] > 0 && fields[n-2]+fields[n-1] == int64(offset) {
+		// contiguous with the previous part
+		fields[n-1] += size
+		return fields
+	}
+	return append(fields, int64(offset), size)
+}
+
+// KeyDescriptor returns the address of a global holding the key
+// fields of maps with keys of type t, and the number of fields.
+func (v *ModuleVisitor) KeyDescriptor(t Type) (lovm.Value, int) {
+	fields := KeyFields(t, 0, nil)
+	layout := fmt.Sprint(fields)
+	if desc, ok := v.KeyDescriptors[layout]; ok {
+		return desc, len(fields) / 2
+	}
+
+	elems := make([]lovm.Value, len(fields))
+	for i, f := range fields {
+		elems[i] = lovm.ConstInt(Uintptr, f)
+	}
+	init := lovm.ConstArray(Uintptr, elems...)
+	name := fmt.Sprintf(".keyfields%d", len(v.KeyDescriptors))
+	global := v.Module.NewGlobal(name, init.Type(), lovm.ValueInitializer{Value: init})
+	desc := lovm.ConstGEP(global, lovm.Indices(0, 0)...)
+	v.KeyDescriptors[layout] = desc
+	return desc, len(fields) / 2
+}
+
+// MakeMap allocates an empty map with room for hint entries.
+func (v *BlockVisitor) MakeMap(mt MapType, hint lovm.Value) lovm.Value {
+	makemap := v.DeclareRuntime("goal_makemap", BytePtr, Uintptr, Uintptr, lovm.PointerType(Uintptr), Uintptr, Uintptr)
+	desc, n := v.KeyDescriptor(mt.Key)
+	return v.Builder.Call(BytePtr, makemap, lovm.ConstInt(Uintptr, int64(Sizeof(mt.Key))),
+		lovm.ConstInt(Uintptr, int64(Sizeof(mt.Value))), desc, lovm.ConstInt(Uintptr, int64(n)), hint)
+}
+
+// Temporary stores a value in a stack slot and returns
+// its address as an i8*, to be passed to the runtime.
+func (v *BlockVisitor) Temporary(value lovm.Value) lovm.Value {
+	return v.Builder.BitCast(v.Spill(value), BytePtr)
+}
+
+// MapKey evaluates a key of a map.
+func (v *BlockVisitor) MapKey(mt MapType, e ast.Expr) lovm.Value {
+	key := v.Evaluate(mt.Key, e)
+	if !key.AssignableTo(mt.Key) {
+		util.Perrorf("cannot use %s (type %v) as type %v in map index", types.ExprString(e), key.Type, mt.Key)
+	}
+	return key.Value
+}
+
+// MapAccess looks up key in the map, returning its value, or the
+// zero value if missing, and whether the key was found.
+func (v *BlockVisitor) MapAccess(mt MapType, m, key lovm.Value) (value, ok lovm.Value) {
+	mapaccess := v.DeclareRuntime("goal_mapaccess", Uintptr, BytePtr, BytePtr, BytePtr)
+	slot := v.Spill(ZeroValue(mt.Value))
+	found := v.Builder.Call(Uintptr, mapaccess, m, v.Temporary(key), v.Builder.BitCast(slot, BytePtr))
+	return v.Builder.Load(slot), v.Builder.IICmp(lovm.IntNE, found, lovm.ConstInt(Uintptr, 0))
+}
+
+// MapAssign sets the value of key in the map.
+func (v *BlockVisitor) MapAssign(mt MapType, m, key, value lovm.Value) {
+	mapassign := v.DeclareRuntime("goal_mapassign", BytePtr, BytePtr, BytePtr)
+	slot := v.Builder.Call(BytePtr, mapassign, m, v.Temporary(key))
+	v.Builder.Store(value, v.Builder.BitCast(slot, lovm.PointerType(mt.Value.LlvmType())))
+}
+
+// MapIndexLValue returns the entry m[k] of a map, which is
+// not addressable but can be assigned to.
+func (v *BlockVisitor) MapIndexLValue(mt MapType, m lovm.Value, n *ast.IndexExpr) LValue {
+	key := v.MapKey(mt, n.Index)
+	return LValue{
+		mt.Value,
+		nil,
+		func() lovm.Value {
+			value, _ := v.MapAccess(mt, m, key)
+			return value
+		},
+		func(value lovm.Value) { v.MapAssign(mt, m, key, value) },
+	}
+}
+
+// MapIndexOk evaluates m[k] in the comma-ok form, returning
+// the value and whether the key was found.
+func (v *BlockVisitor) MapIndexOk(n *ast.IndexExpr) []*ExpressionVisitor {
+	x := v.Evaluate(Any, n.X)
+	mt, ok := Underlying(x.Type).(MapType)
+	if !ok {
+		util.Perrorf("assignment mismatch: 2 variables but 1 value")
+	}
+	value, found := v.MapAccess(mt, x.Value, v.MapKey(mt, n.Index))
+	return []*ExpressionVisitor{{v, value, mt.Value}, {v, found, Bool}}
+}
+
+// MapLit builds a map literal.
+func (v *BlockVisitor) MapLit(mt MapType, elts []ast.Expr) lovm.Value {
+	m := v.MakeMap(mt, lovm.ConstInt(Uintptr, int64(len(elts))))
+	for _, e := range elts {
+		kv, ok := e.(*ast.KeyValueExpr)
+		if !ok {
+			util.Perrorf("missing key in map literal")
+		}
+		key := v.MapKey(mt, kv.Key)
+		value := v.Evaluate(mt.Value, kv.Value)
+		if !value.AssignableTo(mt.Value) {
+			util.Perrorf("cannot use %v as %v value in map literal", value.Type, mt.Value)
+		}
+		v.MapAssign(mt, m, key, value.Value)
+	}
+	return m
+}
+
+// MapLen returns the number of entries of a map as an int.
+func (v *BlockVisitor) MapLen(m lovm.Value) lovm.Value {
+	maplen := v.DeclareRuntime("goal_maplen", Uintptr, BytePtr)
+	return v.Builder.Trunc(v.Builder.Call(Uintptr, maplen, m), Int.LlvmType())
+}
+
+// Delete evaluates delete(m, k).
+func (v *ExpressionVisitor) Delete(call *ast.CallExpr) {
+	checkArgs(call, 2, 2)
+	m := v.BlockVisitor.Evaluate(Any, call.Args[0])
+	mt, ok := Underlying(m.Type).(MapType)
+	if !ok {
+		util.Perrorf("invalid argument: %s (type %v) is not a map", types.ExprString(call.Args[0]), m.Type)
+	}
+	mapdelete := v.DeclareRuntime("goal_mapdelete", lovm.VoidType(), BytePtr, BytePtr)
+	v.Builder.Call(lovm.VoidType(), mapdelete, m.Value, v.Temporary(v.MapKey(mt, call.Args[1])))
+	v.Type = Any
+}
+
+// MapIterator returns the iteration over the entries of a map.
+func (v *BlockVisitor) MapIterator(mt MapType, m lovm.Value) RangeIterator {
+	iterinit := v.DeclareRuntime("goal_mapiterinit", BytePtr, BytePtr)
+	iternext := v.DeclareRuntime("goal_mapiternext", Uintptr, BytePtr, BytePtr, BytePtr)
+	it := v.Builder.Call(BytePtr, iterinit, m)
+	key := v.Builder.Alloca(mt.Key.LlvmType(), 0)
+	value := v.Builder.Alloca(mt.Value.LlvmType(), 0)
+	return RangeIterator{
+		mt.Key,
+		mt.Value,
+		func() lovm.Value {
+			more := v.Builder.Call(Uintptr, iternext, it, v.Builder.BitCast(key, BytePtr), v.Builder.BitCast(value, BytePtr))
+			return v.Builder.IICmp(lovm.IntNE, more, lovm.ConstInt(Uintptr, 0))
+		},
+		func() (lovm.Value, lovm.Value) { return v.Builder.Load(key), v.Builder.Load(value) },
+		func() {},
+	}
+}
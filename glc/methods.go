@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Methods are lowered to functions taking the receiver as their
+// first parameter, named after the package, the receiver base
+// type and the method, as in main.Point.Dist.
+
+// A Method is a function declared with a receiver.
+type Method struct {
+	Name string
+	// name of the llvm function
+	Symbol string
+	// true for receivers of type *T
+	PointerRecv bool
+	// signature, without the receiver
+	Type FunctionType
+	// called through itabs, see interfaces.go
+	wrapper lovm.Value
+}
+
+// AddMethod adds a method to the method set of the type.
+func (t *NamedType) AddMethod(m *Method) {
+	if _, ok := t.Methods[m.Name]; ok {
+		util.Perrorf("method %s.%s already declared", t.Name, m.Name)
+	}
+	if st, ok := t.Underlying().(StructType); ok {
+		for _, f := range st.Fields {
+			if f.Name == m.Name {
+				util.Perrorf("field and method with the same name %s", m.Name)
+			}
+		}
+	}
+	if t.Methods == nil {
+		t.Methods = map[string]*Method{}
+	}
+	t.Methods[m.Name] = m
+}
+
+// LookupMethod returns the method name of values of type t,
+// which can be a named type or a pointer to a named type.
+func LookupMethod(t Type, name string) (*Method, bool) {
+	if ptr, ok := t.(PointerType); ok {
+		t = ptr.Elem
+	}
+	if named, ok := t.(*NamedType); ok {
+		m, ok := named.Methods[name]
+		return m, ok
+	}
+	return nil, false
+}
+
+// DeclareMethod adds the method declared by n to the method set of
+// its receiver base type, which must be a type of the package.
+func (v *ModuleVisitor) DeclareMethod(n *ast.FuncDecl, ft FunctionType) {
+	recv := v.ParseSymbols(n.Recv)
+	if len(recv) != 1 {
+		util.Perrorf("method has multiple receivers")
+	}
+	base, pointer := recv[0].Type, false
+	if ptr, ok := base.(PointerType); ok {
+		base, pointer = ptr.Elem, true
+	}
+	named, ok := base.(*NamedType)
+	if !ok {
+		util.Perrorf("invalid receiver type %s", types.ExprString(n.Recv.List[0].Type))
+	}
+	if _, ok := named.Underlying().(PointerType); ok {
+		util.Perrorf("invalid receiver type %s (pointer or interface type)", named.Name)
+	}
+	if n.Body == nil {
+		util.Perrorf("missing function body")
+	}
+
+	m := &Method{n.Name.Name, fmt.Sprintf("%s.%s.%s", v.Module.Name, named.Name, n.Name.Name), pointer, ft, nil}
+	if m.Name != "_" {
+		named.AddMethod(m)
+	}
+	if pointer {
+		v.PointerMethods[m.Name] = true
+	}
+	full := FunctionType{Params: append(recv, ft.Params...), Results: ft.Results}
+	v.Functions[n] = DeclaredFunction{full, v.Module.NewFunction(m.Symbol, full.FuncType())}
+}
+
+// Receiver returns the receiver passed to the method m called on x
+// at the source position pos, taking its address or dereferencing
+// it as needed.
+func (v *BlockVisitor) Receiver(x LValue, m *Method, pos token.Pos) *ExpressionVisitor {
+	if ptr, ok := x.Type.(PointerType); ok {
+		if m.PointerRecv {
+			return &ExpressionVisitor{v, x.Load(), x.Type}
+		}
+		p := x.Load()
+		v.NilCheck(p, pos)
+		return &ExpressionVisitor{v, v.Builder.Load(p), ptr.Elem}
+	}
+	if m.PointerRecv {
+		if x.Address == nil {
+			util.Perrorf("cannot call pointer method %s on %v", m.Name, x.Type)
+		}
+		return &ExpressionVisitor{v, x.Address, PointerType{x.Type}}
+	}
+	return &ExpressionVisitor{v, x.Load(), x.Type}
+}
+
+// ExprMethod returns the method of the method expression sel,
+// whose operand is the type typ.
+func ExprMethod(sel *ast.SelectorExpr, typ Type) *Method {
+	m, ok := LookupMethod(typ, sel.Sel.Name)
+	if !ok {
+		util.Perrorf("%v has no method %s", typ, sel.Sel.Name)
+	}
+	if _, ok := typ.(PointerType); !ok && m.PointerRecv {
+		util.Perrorf("invalid method expression %s (needs pointer receiver (*%s).%s)", types.ExprString(sel), types.ExprString(sel.X), m.Name)
+	}
+	return m
+}
+
+// CallSelector evaluates the method calls x.M(args) and,
+// for method expressions, T.M(x, args).
+func (v *ExpressionVisitor) CallSelector(sel *ast.SelectorExpr, args []ast.Expr) {
+	if typ, err := v.ResolveType(sel.X); err == nil {
+		m := ExprMethod(sel, typ)
+		if len(args) == 0 {
+			util.Perrorf("not enough arguments in call to %s", types.ExprString(sel))
+		}
+		recv := v.BlockVisitor.Evaluate(typ, args[0])
+		if !Identical(recv.Type, typ) {
+			util.Perrorf("cannot use %v as %v value in argument to %s", recv.Type, typ, types.ExprString(sel))
+		}
+		v.CallFunction(m.Symbol, nil, m.Type, v.Receiver(LValue{typ, nil, func() lovm.Value { return recv.Value }, nil}, m, sel.Sel.Pos()), args[1:])
+		return
+	}
+
+	x := v.Addressable(sel.X)
+	if _, ok := Underlying(x.Type).(*InterfaceType); ok {
+		v.CallInterface(&ExpressionVisitor{v.BlockVisitor, x.Load(), x.Type}, sel.Sel.Name, args)
+		return
+	}
+	m, ok := LookupMethod(x.Type, sel.Sel.Name)
+	if !ok {
+		// fields holding function values
+		fn := &ExpressionVisitor{v.BlockVisitor, nil, Any}
+		fn.Field(&ExpressionVisitor{v.BlockVisitor, x.Load(), x.Type}, sel.Sel.Name, sel.Sel.Pos())
+		v.CallClosure(fn, types.ExprString(sel), args)
+		return
+	}
+	v.CallFunction(m.Symbol, nil, m.Type, v.Receiver(x, m, sel.Sel.Pos()), args)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Variables are held in ssa registers, unless their address is
+// taken. Since the address can be taken after the declaration,
+// function bodies are scanned upfront for such variables, which
+// are then allocated on the heap when declared.
+
+// AddressTaken returns the objects of the variables whose address
+// is taken in body, including by calls to the methods with pointer
+// receivers named in pointerMethods, and of the variables captured
+// by function literals.
+func AddressTaken(body ast.Node, pointerMethods map[string]bool) map[*ast.Object]bool {
+	res := map[*ast.Object]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		var x ast.Expr
+		switch e := n.(type) {
+		case *ast.UnaryExpr:
+			if e.Op == token.AND {
+				x = e.X
+			}
+		case *ast.SliceExpr:
+			// arrays must be addressable to be sliced
+			x = e.X
+		case *ast.SelectorExpr:
+			if pointerMethods[e.Sel.Name] {
+				x = e.X
+			}
+		case *ast.FuncLit:
+			ast.Inspect(e.Body, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && CapturedBy(id, e) {
+					res[id.Obj] = true
+				}
+				return true
+			})
+		}
+		if id := RootIdent(x); id != nil && id.Obj != nil {
+			res[id.Obj] = true
+		}
+		return true
+	})
+	return res
+}
+
+// RootIdent returns the variable an addressable
+// expression is part of, if any.
+func RootIdent(e ast.Expr) *ast.Ident {
+	switch n := e.(type) {
+	case *ast.Ident:
+		return n
+	case *ast.ParenExpr:
+		return RootIdent(n.X)
+	case *ast.SelectorExpr:
+		return RootIdent(n.X)
+	case *ast.IndexExpr:
+		return RootIdent(n.X)
+	}
+	return nil
+}
+
+// MemoryLValue returns the location of a value of type typ at addr.
+func (v *BlockVisitor) MemoryLValue(addr lovm.Value, typ Type) LValue {
+	return LValue{
+		typ,
+		addr,
+		func() lovm.Value { return v.Builder.Load(addr) },
+		func(value lovm.Value) { v.Builder.Store(value, addr) },
+	}
+}
+
+// AddressOf evaluates &x. Taking the address of a composite
+// literal allocates a new variable initialized with it.
+func (v *ExpressionVisitor) AddressOf(x ast.Expr) {
+	if lit, ok := ast.Unparen(x).(*ast.CompositeLit); ok {
+		var hint Type = Any
+		if ptr, ok := Underlying(v.Type).(PointerType); ok {
+			// &T{} with T elided in a composite literal of []*T
+			hint = ptr.Elem
+		}
+		ev := v.BlockVisitor.Evaluate(hint, lit)
+		v.Value = v.Alloc(ev.Type)
+		v.Builder.Store(ev.Value, v.Value)
+		v.Type = PointerType{ev.Type}
+		return
+	}
+
+	lv := v.Addressable(x)
+	if lv.Address == nil {
+		util.Perrorf("cannot take the address of %s", types.ExprString(x))
+	}
+	v.Value, v.Type = lv.Address, PointerType{lv.Type}
+}
+
+// Dereference evaluates a pointer, returning the type it points to.
+// It panics at runtime if the pointer is nil.
+func (v *BlockVisitor) Dereference(x ast.Expr) (lovm.Value, Type) {
+	ev := v.Evaluate(Any, x)
+	ptr, ok := Underlying(ev.Type).(PointerType)
+	if !ok {
+		util.Perrorf("invalid indirect of %s (type %v)", types.ExprString(x), ev.Type)
+	}
+	v.NilCheck(ev.Value, x.Pos())
+	return ev.Value, ptr.Elem
+}
+
+// NilCheck panics at runtime if the pointer ptr, about
+// to be dereferenced at the source position pos, is nil.
+func (v *BlockVisitor) NilCheck(ptr lovm.Value, pos token.Pos) {
+	fail := v.Function.NewBlock()
+	ok := v.Function.NewBlock()
+	v.Builder.BranchIf(v.Builder.IICmp(lovm.IntEQ, ptr, lovm.ConstNull(ptr.Type())), fail, ok)
+	fail.Seal()
+	ok.Seal()
+
+	v.Builder.SetInsertionPoint(fail)
+	panicNil := v.DeclareRuntime("goal_panic_nil", lovm.VoidType(), BytePtr)
+	v.Builder.Call(lovm.VoidType(), panicNil, v.SourcePosition(pos))
+	v.Builder.Unreachable()
+
+	v.Builder.SetInsertionPoint(ok)
+}
+
+// IsNil returns true if e is the predeclared nil.
+func (s *Scope) IsNil(e ast.Expr) bool {
+	id, ok := ast.Unparen(e).(*ast.Ident)
+	if !ok || id.Name != "nil" {
+		return false
+	}
+	_, shadowed := s.LookupSymbol(id.Name)
+	return !shadowed
+}
+
+// Nil evaluates nil, which takes the type of the hint.
+func (v *ExpressionVisitor) Nil() {
+	switch Underlying(v.Type).(type) {
+	case PointerType, MapType, ChanType:
+		v.Value = lovm.ConstNull(v.Type.LlvmType())
+	case SliceType, *InterfaceType, FunctionType:
+		v.Value = lovm.ConstZero(v.Type.LlvmType())
+	default:
+		util.Perrorf("use of untyped nil")
+	}
+}
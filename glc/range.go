@@ -0,0 +1,134 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// A RangeIterator generates the code iterating over
+// the operand of a for statement with a range clause.
+type RangeIterator struct {
+	Key   Type
+	Value Type
+	// Next is called at the beginning of each iteration and
+	// returns an i1 which is false if the iteration is over
+	Next func() lovm.Value
+	// Current returns the key and the value of the iteration
+	Current func() (lovm.Value, lovm.Value)
+	// Advance is called at the end of each iteration
+	Advance func()
+}
+
+// IndexIterator returns the iteration over the elements of
+// an array or slice of length elements, which are loaded
+// from memory starting at ptr.
+func (v *BlockVisitor) IndexIterator(elem Type, ptr, length lovm.Value) RangeIterator {
+	i := Symbol{Type: Int64, Id: v.VarSequence.Next()}
+	v.WriteVar(i, lovm.ConstInt(Int64.LlvmType(), 0))
+	return RangeIterator{
+		Int,
+		elem,
+		func() lovm.Value { return v.Builder.IICmp(lovm.IntSLT, v.ReadVar(i), length) },
+		func() (lovm.Value, lovm.Value) {
+			idx := v.ReadVar(i)
+			return v.Builder.Trunc(idx, Int.LlvmType()), v.Builder.Load(v.Builder.GEP(ptr, idx))
+		},
+		func() { v.WriteVar(i, v.Builder.IAdd(v.ReadVar(i), lovm.ConstInt(Int64.LlvmType(), 1))) },
+	}
+}
+
+// Iterator returns the iteration over the value of x.
+func (v *BlockVisitor) Iterator(x *ExpressionVisitor, e ast.Expr) RangeIterator {
+	typ := Underlying(x.Type)
+	if ptr, ok := typ.(PointerType); ok {
+		if at, ok := Underlying(ptr.Elem).(ArrayType); ok {
+			// range over *p for pointers to arrays
+			v.NilCheck(x.Value, e.Pos())
+			first := v.Builder.GEP(x.Value, lovm.Indices(0, 0)...)
+			return v.IndexIterator(at.Elem, first, lovm.ConstInt(Int64.LlvmType(), int64(at.Len)))
+		}
+	}
+	switch t := typ.(type) {
+	case ArrayType:
+		// the array is copied before the iteration
+		first := v.Builder.GEP(v.Spill(x.Value), lovm.Indices(0, 0)...)
+		return v.IndexIterator(t.Elem, first, lovm.ConstInt(Int64.LlvmType(), int64(t.Len)))
+	case SliceType:
+		ptr, length, _ := v.SliceFields(x.Value)
+		return v.IndexIterator(t.Elem, ptr, length)
+	case MapType:
+		return v.MapIterator(t, x.Value)
+	case ChanType:
+		return v.ChanIterator(t, x, e)
+	}
+	util.Perrorf("cannot range over %s (type %v)", types.ExprString(e), x.Type)
+	return RangeIterator{}
+}
+
+// CompileRange compiles a for statement with a range clause. The
+// range expression is evaluated once, before the loop starts.
+func (v *BlockVisitor) CompileRange(n *ast.RangeStmt, label string) {
+	x := v.Evaluate(Any, n.X)
+	it := v.Iterator(x, n.X)
+	if it.Value == nil && n.Value != nil {
+		util.Perrorf("range over %s permits only one iteration variable", types.ExprString(n.X))
+	}
+
+	header := v.Function.NewBlock()
+	body := v.Function.NewBlock()
+	post := v.Function.NewBlock()
+	exit := v.Function.NewBlock()
+
+	v.Builder.Branch(header)
+	v.Builder.SetInsertionPoint(header)
+	v.Builder.BranchIf(it.Next(), body, exit)
+	body.Seal()
+
+	v.Builder.SetInsertionPoint(body)
+	// the iteration variables are in an implicit
+	// block enclosing the body of the loop
+	lv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+	key, value := it.Current()
+	lv.AssignRange(n, n.Key, it.Key, key)
+	lv.AssignRange(n, n.Value, it.Value, value)
+
+	v.PushTarget(BranchTarget{label, exit, post})
+	lv.EvaluateBlock(n.Body)
+	v.PopTarget()
+	v.Builder.Branch(post)
+	post.Seal()
+
+	v.Builder.SetInsertionPoint(post)
+	it.Advance()
+	v.Builder.Branch(header)
+	header.Seal()
+
+	exit.Seal()
+	v.Builder.SetInsertionPoint(exit)
+}
+
+// AssignRange declares or assigns an iteration variable,
+// if present in the range clause.
+func (v *BlockVisitor) AssignRange(n *ast.RangeStmt, e ast.Expr, typ Type, value lovm.Value) {
+	if e == nil {
+		return
+	}
+	if n.Tok == token.DEFINE {
+		id, ok := e.(*ast.Ident)
+		if !ok {
+			util.Perrorf("non-name %s on left side of :=", types.ExprString(e))
+		}
+		if id.Name != "_" {
+			v.DeclareVar(id, typ, value)
+		}
+		return
+	}
+	lv := v.Addressable(e)
+	if lv.Type != Any && !Identical(typ, lv.Type) {
+		util.Perrorf("cannot assign %v to %s (type %v) in range", typ, types.ExprString(e), lv.Type)
+	}
+	lv.Store(value)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"go/token"
+	"goal/lovm"
+)
+
+var (
+	// Uintptr is the llvm type of sizes passed to the runtime.
+	Uintptr = lovm.IntType(PointerSize * 8)
+	BytePtr = lovm.PointerType(lovm.IntType(8))
+	RunePtr = lovm.PointerType(lovm.IntType(32))
+)
+
+// DeclareRuntime declares an external function provided by the
+// runtime the first time it's used, and returns its name.
+func (v *ModuleVisitor) DeclareRuntime(name string, ret lovm.Type, params ...lovm.Type) string {
+	if !v.Externals[name] {
+		v.Module.DeclareExternal(name, lovm.FunctionType(ret, false, params...))
+		v.Externals[name] = true
+	}
+	return name
+}
+
+// Alloc allocates a zeroed value of type typ on the heap and
+// returns its address. Memory is never freed.
+func (v *BlockVisitor) Alloc(typ Type) lovm.Value {
+	alloc := v.DeclareRuntime("goal_alloc", BytePtr, Uintptr, Uintptr)
+	mem := v.Builder.Call(BytePtr, alloc, lovm.ConstInt(Uintptr, 1), lovm.ConstInt(Uintptr, int64(Sizeof(typ))))
+	return v.Builder.BitCast(mem, lovm.PointerType(typ.LlvmType()))
+}
+
+// SourcePosition returns a C string describing a position
+// in the source, for runtime error messages.
+func (v *BlockVisitor) SourcePosition(pos token.Pos) lovm.Value {
+	str := v.Module.ConstString(v.Position(pos).String())
+	return v.Builder.GEP(str, lovm.Indices(0, 0)...)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Slices are lowered to a { T*, int, int } header holding the
+// pointer to the first element, the length and the capacity.
+// The backing arrays are allocated by the runtime.
+
+// Extend returns an integer value converted to int64,
+// the type used for sizes and indices passed to the runtime.
+func (v *BlockVisitor) Extend(value lovm.Value, typ Type) lovm.Value {
+	conv := &ExpressionVisitor{v, nil, Int64}
+	conv.Convert(&ExpressionVisitor{v, value, typ}, Int64)
+	return conv.Value
+}
+
+// Size evaluates the length or capacity argument of make.
+func (v *BlockVisitor) Size(e ast.Expr) lovm.Value {
+	if c, ok := v.ConstValue(e); ok {
+		return lovm.ConstInt(Int64.LlvmType(), int64(ConstIndex(c, -1)))
+	}
+	ev := v.Evaluate(Int, e)
+	if !IsInteger(ev.Type) {
+		util.Perrorf("cannot convert %s (type %v) to type int", types.ExprString(e), ev.Type)
+	}
+	return v.Extend(ev.Value, ev.Type)
+}
+
+// SliceHeader builds a slice from the pointer to its first
+// element and its length and capacity as int64.
+func (v *BlockVisitor) SliceHeader(st SliceType, ptr, length, capacity lovm.Value) lovm.Value {
+	res := v.Builder.InsertValue(lovm.Undef(st.LlvmType()), ptr, 0)
+	res = v.Builder.InsertValue(res, v.Builder.Trunc(length, Int.LlvmType()), 1)
+	return v.Builder.InsertValue(res, v.Builder.Trunc(capacity, Int.LlvmType()), 2)
+}
+
+// SliceFields returns the pointer, length and capacity of a slice,
+// the latter two as int64.
+func (v *BlockVisitor) SliceFields(s lovm.Value) (ptr, length, capacity lovm.Value) {
+	ptr = v.Builder.ExtractValue(s, 0)
+	length = v.Extend(v.Builder.ExtractValue(s, 1), Int)
+	capacity = v.Extend(v.Builder.ExtractValue(s, 2), Int)
+	return
+}
+
+func elemSize(st SliceType) lovm.Value {
+	return lovm.ConstInt(Uintptr, int64(Sizeof(st.Elem)))
+}
+
+// MakeSlice allocates a zeroed backing array.
+func (v *BlockVisitor) MakeSlice(st SliceType, length, capacity lovm.Value) lovm.Value {
+	makeslice := v.DeclareRuntime("goal_makeslice", BytePtr, Uintptr, Uintptr, Uintptr)
+	mem := v.Builder.Call(BytePtr, makeslice, length, capacity, elemSize(st))
+	ptr := v.Builder.BitCast(mem, lovm.PointerType(st.Elem.LlvmType()))
+	return v.SliceHeader(st, ptr, length, capacity)
+}
+
+// Grow returns the slice s with enough capacity to append
+// n more elements, reallocating the backing array if needed.
+func (v *BlockVisitor) Grow(st SliceType, s, n lovm.Value) lovm.Value {
+	growslice := v.DeclareRuntime("goal_growslice", lovm.VoidType(), BytePtr, Uintptr, Uintptr)
+	header := v.Spill(s)
+	v.Builder.Call(lovm.VoidType(), growslice, v.Builder.BitCast(header, BytePtr), n, elemSize(st))
+	return v.Builder.Load(header)
+}
+
+// AppendValues appends elems to the slice s.
+func (v *BlockVisitor) AppendValues(st SliceType, s lovm.Value, elems []lovm.Value) lovm.Value {
+	if len(elems) == 0 {
+		return s
+	}
+	n := lovm.ConstInt(Int64.LlvmType(), int64(len(elems)))
+	ptr, length, capacity := v.SliceFields(v.Grow(st, s, n))
+	for i, e := range elems {
+		idx := v.Builder.IAdd(length, lovm.ConstInt(Int64.LlvmType(), int64(i)))
+		v.Builder.Store(e, v.Builder.GEP(ptr, idx))
+	}
+	return v.SliceHeader(st, ptr, v.Builder.IAdd(length, n), capacity)
+}
+
+// AppendSlice appends the elements of the slice t to the slice s.
+func (v *BlockVisitor) AppendSlice(st SliceType, s, t lovm.Value) lovm.Value {
+	src, n, _ := v.SliceFields(t)
+	ptr, length, capacity := v.SliceFields(v.Grow(st, s, n))
+	v.copyElements(st, v.Builder.GEP(ptr, length), n, src, n)
+	return v.SliceHeader(st, ptr, v.Builder.IAdd(length, n), capacity)
+}
+
+// CopySlice copies the elements of src to dst, returning
+// the number of elements copied as an int.
+func (v *BlockVisitor) CopySlice(st SliceType, dst, src lovm.Value) lovm.Value {
+	dstPtr, dstLen, _ := v.SliceFields(dst)
+	srcPtr, srcLen, _ := v.SliceFields(src)
+	return v.Builder.Trunc(v.copyElements(st, dstPtr, dstLen, srcPtr, srcLen), Int.LlvmType())
+}
+
+func (v *BlockVisitor) copyElements(st SliceType, dst, dstLen, src, srcLen lovm.Value) lovm.Value {
+	slicecopy := v.DeclareRuntime("goal_slicecopy", Uintptr, BytePtr, Uintptr, BytePtr, Uintptr, Uintptr)
+	return v.Builder.Call(Uintptr, slicecopy, v.Builder.BitCast(dst, BytePtr), dstLen,
+		v.Builder.BitCast(src, BytePtr), srcLen, elemSize(st))
+}
+
+// SliceLit builds a slice literal, allocating its backing array.
+func (v *BlockVisitor) SliceLit(st SliceType, elts []ast.Expr) lovm.Value {
+	indices, length := v.ElementIndices(elts, -1)
+	n := lovm.ConstInt(Int64.LlvmType(), int64(length))
+	s := v.MakeSlice(st, n, n)
+	ptr := v.Builder.ExtractValue(s, 0)
+	for i, e := range elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			e = kv.Value
+		}
+		ev := v.Evaluate(st.Elem, e)
+		if !ev.AssignableTo(st.Elem) {
+			util.Perrorf("cannot use %v as %v value in slice literal", ev.Type, st.Elem)
+		}
+		idx := lovm.ConstInt(Int64.LlvmType(), int64(indices[i]))
+		v.Builder.Store(ev.Value, v.Builder.GEP(ptr, idx))
+	}
+	return s
+}
+
+// SliceIndexLValue returns the location of the element s[i] of a slice.
+func (v *BlockVisitor) SliceIndexLValue(st SliceType, s lovm.Value, n *ast.IndexExpr) LValue {
+	ptr, length, _ := v.SliceFields(s)
+	idx, _ := v.Index(n.Index, -1)
+	v.BoundsCheck(idx, length, n.Lbrack)
+	return v.MemoryLValue(v.Builder.GEP(ptr, idx), st.Elem)
+}
+
+// SliceExpr evaluates x[lo:hi:max] on arrays, pointers
+// to arrays and slices, and x[lo:hi] on strings.
+func (v *ExpressionVisitor) SliceExpr(n *ast.SliceExpr) {
+	x := v.Addressable(n.X)
+	if ptr, ok := Underlying(x.Type).(PointerType); ok {
+		if _, ok := Underlying(ptr.Elem).(ArrayType); ok {
+			p := x.Load()
+			v.NilCheck(p, n.Lbrack)
+			x = v.MemoryLValue(p, ptr.Elem)
+		}
+	}
+
+	var st SliceType
+	var ptr, length, capacity lovm.Value
+	switch t := Underlying(x.Type).(type) {
+	case ArrayType:
+		if x.Address == nil {
+			util.Perrorf("invalid operation: %s (slice of unaddressable value)", types.ExprString(n))
+		}
+		st = SliceType{t.Elem}
+		v.Type = st
+		ptr = v.Builder.GEP(x.Address, lovm.Indices(0, 0)...)
+		length = lovm.ConstInt(Int64.LlvmType(), int64(t.Len))
+		capacity = length
+	case SliceType:
+		st = t
+		v.Type = x.Type
+		ptr, length, capacity = v.SliceFields(x.Load())
+	case PrimitiveType:
+		if t != String {
+			util.Perrorf("cannot slice %s (type %v)", types.ExprString(n.X), x.Type)
+		}
+		if n.Slice3 {
+			util.Perrorf("invalid operation: 3-index slice of string")
+		}
+		v.Type = x.Type
+		ptr, length = v.StringFields(x.Load())
+		capacity = length
+	default:
+		util.Perrorf("cannot slice %s (type %v)", types.ExprString(n.X), x.Type)
+	}
+
+	lo := lovm.Value(lovm.ConstInt(Int64.LlvmType(), 0))
+	if n.Low != nil {
+		lo, _ = v.Index(n.Low, -1)
+	}
+	hi := length
+	if n.High != nil {
+		hi, _ = v.Index(n.High, -1)
+	}
+	max := capacity
+	if n.Max != nil {
+		max, _ = v.Index(n.Max, -1)
+	}
+	v.SliceCheck(lo, hi, max, capacity, n.Lbrack)
+	if Underlying(v.Type) == String {
+		v.Value = v.StringHeader(v.Builder.GEP(ptr, lo), v.Builder.ISub(hi, lo))
+		return
+	}
+	v.Value = v.SliceHeader(st, v.Builder.GEP(ptr, lo), v.Builder.ISub(hi, lo), v.Builder.ISub(max, lo))
+}
+
+// SliceCheck panics at runtime unless 0 <= lo <= hi <= max <= capacity.
+func (v *BlockVisitor) SliceCheck(lo, hi, max, capacity lovm.Value, pos token.Pos) {
+	fail := v.Function.NewBlock()
+	ok := v.Function.NewBlock()
+	outOfRange := v.Builder.Or(v.Builder.IICmp(lovm.IntUGT, lo, hi), v.Builder.IICmp(lovm.IntUGT, hi, max))
+	outOfRange = v.Builder.Or(outOfRange, v.Builder.IICmp(lovm.IntUGT, max, capacity))
+	v.Builder.BranchIf(outOfRange, fail, ok)
+	fail.Seal()
+	ok.Seal()
+
+	v.Builder.SetInsertionPoint(fail)
+	panicSlice := v.DeclareRuntime("goal_panic_slice", lovm.VoidType(), BytePtr, Uintptr, Uintptr, Uintptr, Uintptr)
+	v.Builder.Call(lovm.VoidType(), panicSlice, v.SourcePosition(pos), lo, hi, max, capacity)
+	v.Builder.Unreachable()
+
+	v.Builder.SetInsertionPoint(ok)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Strings are lowered to a { i8*, int } header holding the pointer
+// to the first byte and the length. The bytes are never modified,
+// thus slices and copies of a string share them.
+
+// ConstStringHeader returns the header of a string constant,
+// whose bytes are interned by the module.
+func ConstStringHeader(mod *lovm.Module, typ Type, s string) lovm.Value {
+	ptr := lovm.ConstGEP(mod.ConstString(s), lovm.Indices(0, 0)...)
+	return lovm.ConstStruct(typ.LlvmType(), ptr, lovm.ConstInt(Int.LlvmType(), int64(len(s))))
+}
+
+// StringHeader builds a string from the pointer to its
+// first byte and its length as int64.
+func (v *BlockVisitor) StringHeader(ptr, length lovm.Value) lovm.Value {
+	res := v.Builder.InsertValue(lovm.Undef(String.LlvmType()), ptr, 0)
+	return v.Builder.InsertValue(res, v.Builder.Trunc(length, Int.LlvmType()), 1)
+}
+
+// StringFields returns the pointer and the length
+// of a string, the latter as int64.
+func (v *BlockVisitor) StringFields(s lovm.Value) (ptr, length lovm.Value) {
+	ptr = v.Builder.ExtractValue(s, 0)
+	length = v.Extend(v.Builder.ExtractValue(s, 1), Int)
+	return
+}
+
+// Concat returns the concatenation of two strings.
+func (v *BlockVisitor) Concat(x, y lovm.Value) lovm.Value {
+	concat := v.DeclareRuntime("goal_concatstrings", BytePtr, BytePtr, Uintptr, BytePtr, Uintptr)
+	xPtr, xLen := v.StringFields(x)
+	yPtr, yLen := v.StringFields(y)
+	ptr := v.Builder.Call(BytePtr, concat, xPtr, xLen, yPtr, yLen)
+	return v.StringHeader(ptr, v.Builder.IAdd(xLen, yLen))
+}
+
+// CompareStrings returns an int64 which is negative, zero or
+// positive if x is less than, equal to or greater than y.
+func (v *BlockVisitor) CompareStrings(x, y lovm.Value) lovm.Value {
+	cmp := v.DeclareRuntime("goal_cmpstring", Uintptr, BytePtr, Uintptr, BytePtr, Uintptr)
+	xPtr, xLen := v.StringFields(x)
+	yPtr, yLen := v.StringFields(y)
+	return v.Builder.Call(Uintptr, cmp, xPtr, xLen, yPtr, yLen)
+}
+
+// StringOp computes x op y on strings.
+func (v *ExpressionVisitor) StringOp(op token.Token, xev, yev *ExpressionVisitor) {
+	zero := lovm.ConstInt(Uintptr, 0)
+	switch op {
+	case token.ADD:
+		v.Value = v.Concat(xev.Value, yev.Value)
+		return
+	case token.EQL:
+		v.Value = v.Equal(xev.Value, yev.Value, xev.Type)
+	case token.NEQ:
+		v.Value = v.Builder.IICmp(lovm.IntNE, v.CompareStrings(xev.Value, yev.Value), zero)
+	case token.LSS:
+		v.Value = v.Builder.IICmp(lovm.IntSLT, v.CompareStrings(xev.Value, yev.Value), zero)
+	case token.LEQ:
+		v.Value = v.Builder.IICmp(lovm.IntSLE, v.CompareStrings(xev.Value, yev.Value), zero)
+	case token.GTR:
+		v.Value = v.Builder.IICmp(lovm.IntSGT, v.CompareStrings(xev.Value, yev.Value), zero)
+	case token.GEQ:
+		v.Value = v.Builder.IICmp(lovm.IntSGE, v.CompareStrings(xev.Value, yev.Value), zero)
+	default:
+		util.Perrorf("invalid operation: operator %v not defined on %v", op, xev.Type)
+	}
+	v.Type = Bool
+}
+
+// StringIndexLValue returns the byte s[i] of a string,
+// which cannot be assigned to.
+func (v *BlockVisitor) StringIndexLValue(s lovm.Value, n *ast.IndexExpr) LValue {
+	ptr, length := v.StringFields(s)
+	idx, _ := v.Index(n.Index, -1)
+	v.BoundsCheck(idx, length, n.Lbrack)
+	b := v.Builder.Load(v.Builder.GEP(ptr, idx))
+	return LValue{
+		Uint8,
+		nil,
+		func() lovm.Value { return b },
+		func(lovm.Value) {
+			util.Perrorf("cannot assign to %s (strings are immutable)", types.ExprString(n))
+		},
+	}
+}
+
+// isSliceOf returns true if t is a slice of elements
+// whose underlying type is elem.
+func isSliceOf(t Type, elem Type) bool {
+	st, ok := Underlying(t).(SliceType)
+	return ok && Underlying(st.Elem) == elem
+}
+
+// ConvertString converts between strings and slices of bytes or
+// runes, copying the contents. It returns false if the conversion
+// is not one of those.
+func (v *ExpressionVisitor) ConvertString(ev *ExpressionVisitor, typ Type) bool {
+	from, to := Underlying(ev.Type), Underlying(typ)
+	switch {
+	case to == String && isSliceOf(from, Uint8):
+		ptr, length, _ := v.SliceFields(ev.Value)
+		conv := v.DeclareRuntime("goal_slicebytetostring", BytePtr, BytePtr, Uintptr)
+		v.Value = v.StringHeader(v.Builder.Call(BytePtr, conv, ptr, length), length)
+	case to == String && isSliceOf(from, Int32):
+		runes, n, _ := v.SliceFields(ev.Value)
+		encodedLen := v.DeclareRuntime("goal_encodedlen", Uintptr, RunePtr, Uintptr)
+		length := v.Builder.Call(Uintptr, encodedLen, runes, n)
+		conv := v.DeclareRuntime("goal_slicerunetostring", BytePtr, RunePtr, Uintptr, Uintptr)
+		v.Value = v.StringHeader(v.Builder.Call(BytePtr, conv, runes, n, length), length)
+	case from == String && isSliceOf(to, Uint8):
+		ptr, length := v.StringFields(ev.Value)
+		conv := v.DeclareRuntime("goal_stringtoslicebyte", BytePtr, BytePtr, Uintptr)
+		v.Value = v.SliceHeader(to.(SliceType), v.Builder.Call(BytePtr, conv, ptr, length), length, length)
+	case from == String && isSliceOf(to, Int32):
+		ptr, length := v.StringFields(ev.Value)
+		count := v.DeclareRuntime("goal_countrunes", Uintptr, BytePtr, Uintptr)
+		n := v.Builder.Call(Uintptr, count, ptr, length)
+		conv := v.DeclareRuntime("goal_stringtoslicerune", RunePtr, BytePtr, Uintptr, Uintptr)
+		v.Value = v.SliceHeader(to.(SliceType), v.Builder.Call(RunePtr, conv, ptr, length, n), n, n)
+	default:
+		return false
+	}
+	v.Type = typ
+	return true
+}
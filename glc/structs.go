@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"goal/lovm"
+	"goal/util"
+)
+
+// DeclareTypes declares the package level types. Their underlying
+// types are resolved after the constants are declared, since both
+// can refer to each other.
+func (v *ModuleVisitor) DeclareTypes(decls []ast.Decl) (res []*NamedType) {
+	for _, d := range decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+			for _, sp := range gen.Specs {
+				ts := sp.(*ast.TypeSpec)
+				func() {
+					defer ReportErrors(v.Scope, ts)
+					name := fmt.Sprintf("%s.%s", v.Module.Name, ts.Name.Name)
+					if named := v.DeclareType(v.Module, ts, name); named != nil {
+						res = append(res, named)
+					}
+				}()
+			}
+		}
+	}
+	return
+}
+
+// ResolveTypes resolves the underlying types of named types,
+// reporting errors in their definitions.
+func (v *ModuleVisitor) ResolveTypes(types []*NamedType) {
+	for _, t := range types {
+		func() {
+			defer ReportErrors(v.Scope, t.Spec)
+			t.Underlying()
+		}()
+	}
+}
+
+// AddTypes declares the types of a type declaration in a block.
+// Local types are given unique llvm names, since different
+// blocks can declare types with the same name.
+func (v *BlockVisitor) AddTypes(gen *ast.GenDecl) {
+	for _, sp := range gen.Specs {
+		ts := sp.(*ast.TypeSpec)
+		name := fmt.Sprintf("%s.%s.%d", v.Module.Name, ts.Name.Name, v.VarSequence.Next())
+		if named := v.DeclareType(v.Module, ts, name); named != nil {
+			named.Underlying()
+		}
+	}
+}
+
+// DeclareType adds the type defined by spec to the scope. Named
+// struct types are lowered to the identified llvm type llvmName.
+// It returns nil for aliases.
+func (s *Scope) DeclareType(mod *lovm.Module, spec *ast.TypeSpec, llvmName string) *NamedType {
+	sym := Symbol{Name: spec.Name.Name, Id: s.VarSequence.Next(), TypeName: true}
+	var named *NamedType
+	if spec.Assign.IsValid() {
+		// type A = B
+		sym.Type = s.ParseType(spec.Type)
+	} else {
+		named = &NamedType{Name: spec.Name.Name, Package: mod.Name, Spec: spec, Scope: s}
+		if _, ok := spec.Type.(*ast.StructType); ok {
+			named.llvmType = mod.NewNamedType(llvmName)
+		}
+		sym.Type = named
+	}
+	if spec.Name.Name == "_" {
+		return named
+	}
+	if err := s.AddVar(sym); err != nil {
+		util.Perrorf("cannot add type %s: %s", spec.Name.Name, err)
+	}
+	return named
+}
+
+func (s *Scope) ResolveStructType(st *ast.StructType) (Type, error) {
+	var res StructType
+	seen := map[string]bool{}
+	for _, f := range st.Fields.List {
+		typ, err := s.ResolveType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		if f.Names == nil {
+			// embedded fields are named after their type
+			id, ok := f.Type.(*ast.Ident)
+			if !ok {
+				return nil, fmt.Errorf("NOT IMPLEMENTED YET: embedded field %#v", f.Type)
+			}
+			res.Fields = append(res.Fields, Field{id.Name, typ, true})
+		}
+		for _, n := range f.Names {
+			res.Fields = append(res.Fields, Field{n.Name, typ, false})
+		}
+	}
+	for _, f := range res.Fields {
+		if f.Name != "_" && seen[f.Name] {
+			return nil, fmt.Errorf("duplicate field %s", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return res, nil
+}
+
+// CompositeLit evaluates a composite literal. The type can be
+// omitted for elements of array, slice and map literals, and is
+// then taken from the type hint.
+func (v *ExpressionVisitor) CompositeLit(n *ast.CompositeLit) {
+	typ := v.Type
+	if at, ok := n.Type.(*ast.ArrayType); ok && at.Len != nil && isEllipsis(at.Len) {
+		// [...]T{...}
+		_, length := v.ElementIndices(n.Elts, -1)
+		typ = ArrayType{length, v.ParseType(at.Elt)}
+	} else if n.Type != nil {
+		typ = v.ParseType(n.Type)
+	} else if typ == nil || typ == Any {
+		util.Perrorf("invalid composite literal type: missing type")
+	} else if _, ok := Underlying(typ).(PointerType); ok {
+		// &T elided in a composite literal of []*T
+		v.AddressOf(n)
+		return
+	}
+
+	switch t := Underlying(typ).(type) {
+	case StructType:
+		v.Value = v.StructLit(typ, t, n.Elts)
+	case ArrayType:
+		v.Value = v.ArrayLit(typ, t, n.Elts)
+	case SliceType:
+		v.Value = v.SliceLit(t, n.Elts)
+	case MapType:
+		v.Value = v.MapLit(t, n.Elts)
+	default:
+		util.Perrorf("invalid composite literal type %v", typ)
+	}
+	v.Type = typ
+}
+
+func isEllipsis(e ast.Expr) bool {
+	_, ok := e.(*ast.Ellipsis)
+	return ok
+}
+
+// StructLit builds a struct value. The elements either list all
+// the fields in order, or are keyed by field name, with omitted
+// fields taking their zero value.
+func (v *BlockVisitor) StructLit(typ Type, st StructType, elts []ast.Expr) lovm.Value {
+	keyed := false
+	if len(elts) > 0 {
+		_, keyed = elts[0].(*ast.KeyValueExpr)
+	}
+	if !keyed && len(elts) > 0 && len(elts) != len(st.Fields) {
+		util.Perrorf("wrong number of values in struct literal of type %v: have %d, want %d", typ, len(elts), len(st.Fields))
+	}
+
+	res := ZeroValue(typ)
+	seen := map[int]bool{}
+	for i, e := range elts {
+		idx, value := i, e
+		if kv, ok := e.(*ast.KeyValueExpr); ok != keyed {
+			util.Perrorf("mixture of field:value and value elements in struct literal")
+		} else if ok {
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				util.Perrorf("invalid field name in struct literal of type %v", typ)
+			}
+			idx, _ = FieldByName(typ, key.Name)
+			if seen[idx] {
+				util.Perrorf("duplicate field name %s in struct literal", key.Name)
+			}
+			seen[idx] = true
+			value = kv.Value
+		}
+
+		field := st.Fields[idx]
+		ev := v.Evaluate(field.Type, value)
+		if !ev.AssignableTo(field.Type) {
+			util.Perrorf("cannot use %v as %v value in struct literal", ev.Type, field.Type)
+		}
+		res = v.Builder.InsertValue(res, ev.Value, idx)
+	}
+	return res
+}
+
+// Selector evaluates the field x.name of a struct value, or of
+// the struct pointed to by x, and method values and expressions.
+func (v *ExpressionVisitor) Selector(n *ast.SelectorExpr) {
+	if typ, err := v.ResolveType(n.X); err == nil {
+		v.MethodExpr(n, typ)
+		return
+	}
+	x := v.Addressable(n.X)
+	_, isInterface := Underlying(x.Type).(*InterfaceType)
+	if m, ok := LookupMethod(x.Type, n.Sel.Name); ok || isInterface {
+		v.MethodValue(x, n.Sel, m)
+		return
+	}
+	v.Field(&ExpressionVisitor{v.BlockVisitor, x.Load(), x.Type}, n.Sel.Name, n.Sel.Pos())
+}
+
+// Field evaluates the field name of the struct x, or of
+// the struct x points to, selected at the source position pos.
+func (v *ExpressionVisitor) Field(x *ExpressionVisitor, name string, pos token.Pos) {
+	if ptr, ok := Underlying(x.Type).(PointerType); ok {
+		v.NilCheck(x.Value, pos)
+		idx, field := FieldByName(ptr.Elem, name)
+		v.Value = v.Builder.Load(v.Builder.GEP(x.Value, lovm.Indices(0, idx)...))
+		v.Type = field.Type
+		return
+	}
+	idx, field := FieldByName(x.Type, name)
+	v.Value = v.Builder.ExtractValue(x.Value, idx)
+	v.Type = field.Type
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"goal/lovm"
+	"goal/util"
+)
+
+// Switches on integers with at least MinDenseCases constant cases,
+// spanning at most twice as many values, are lowered to a switch
+// instruction rather than to comparisons.
+const MinDenseCases = 4
+
+// SwitchConstants returns the values of the cases of a switch on tag
+// if they are all integer constants, and checks that the constant
+// cases are distinct.
+func (v *BlockVisitor) SwitchConstants(tag *ExpressionVisitor, clauses []*ast.CaseClause) ([]int64, bool) {
+	var values []int64
+	dense := tag != nil && IsInteger(tag.Type)
+	seen := map[string]bool{}
+	for _, cc := range clauses {
+		for _, e := range cc.List {
+			c, ok := v.ConstValue(e)
+			if !ok {
+				dense = false
+				continue
+			}
+			if tag == nil {
+				continue
+			}
+			key := c.Value.ExactString()
+			if seen[key] {
+				util.Perrorf("duplicate case %s in expression switch", types.ExprString(e))
+			}
+			seen[key] = true
+			x, exact := constant.Int64Val(constant.ToInt(c.Value))
+			if !exact {
+				dense = false
+			}
+			values = append(values, x)
+		}
+	}
+	return values, dense
+}
+
+// Dense returns true if the integers are many enough
+// and close enough to each other for a switch instruction.
+func Dense(values []int64) bool {
+	if len(values) < MinDenseCases {
+		return false
+	}
+	min, max := values[0], values[0]
+	for _, x := range values {
+		if x < min {
+			min = x
+		}
+		if x > max {
+			max = x
+		}
+	}
+	return uint64(max-min) < 2*uint64(len(values))
+}
+
+// CaseValue evaluates an expression of a case clause of a switch on tag.
+func (v *BlockVisitor) CaseValue(tag *ExpressionVisitor, e ast.Expr) *ExpressionVisitor {
+	x := v.Evaluate(tag.Type, e)
+	_, xi := Underlying(x.Type).(*InterfaceType)
+	_, ti := Underlying(tag.Type).(*InterfaceType)
+	if !xi && !ti && !v.IsNil(e) && !Identical(x.Type, tag.Type) {
+		util.Perrorf("invalid case %s in switch (mismatched types %v and %v)", types.ExprString(e), x.Type, tag.Type)
+	}
+	return x
+}
+
+// CaseCondition evaluates whether the expression of a case clause
+// matches the tag of the switch, or is true for tagless switches.
+func (v *BlockVisitor) CaseCondition(tag *ExpressionVisitor, e ast.Expr) lovm.Value {
+	if tag == nil {
+		cond := v.Evaluate(Bool, e)
+		if Underlying(cond.Type) != Bool {
+			util.Perrorf("invalid case %s in switch (mismatched types %v and bool)", types.ExprString(e), cond.Type)
+		}
+		return cond.Value
+	}
+	x := v.CaseValue(tag, e)
+	eq := &ExpressionVisitor{v, nil, Bool}
+	eq.BinaryOp(token.EQL, &ExpressionVisitor{v, tag.Value, tag.Type}, x, e.Pos())
+	return eq.Value
+}
+
+// CompileSwitch compiles an expression switch. The cases are tested
+// in order, the default clause, wherever it is, being taken if none
+// matches, unless they are dense integer constants, which are lowered
+// to a switch instruction. A clause ending with a fallthrough statement
+// continues with the body of the next clause.
+func (v *BlockVisitor) CompileSwitch(n *ast.SwitchStmt, label string) {
+	sv := &BlockVisitor{NewScope(&v.Scope), v.FunctionVisitor}
+	if n.Init != nil {
+		Walk(sv, n.Init)
+	}
+	var tag *ExpressionVisitor
+	if n.Tag != nil {
+		tag = sv.Evaluate(Any, n.Tag)
+		if !Comparable(tag.Type) {
+			util.Perrorf("cannot switch on %s (%v is not comparable)", types.ExprString(n.Tag), tag.Type)
+		}
+	}
+
+	var clauses []*ast.CaseClause
+	bodies := make([]*lovm.Block, len(n.Body.List))
+	exit := v.Function.NewBlock()
+	def := exit
+	for i, s := range n.Body.List {
+		cc := s.(*ast.CaseClause)
+		clauses = append(clauses, cc)
+		bodies[i] = v.Function.NewBlock()
+		if cc.List == nil {
+			if def != exit {
+				util.Perrorf("multiple defaults in switch")
+			}
+			def = bodies[i]
+		}
+	}
+
+	if values, dense := sv.SwitchConstants(tag, clauses); dense && Dense(values) {
+		var cases []lovm.Value
+		var targets []*lovm.Block
+		for i, cc := range clauses {
+			for _, e := range cc.List {
+				cases = append(cases, sv.CaseValue(tag, e).Value)
+				targets = append(targets, bodies[i])
+			}
+		}
+		v.Builder.Switch(tag.Value, def, cases, targets)
+	} else {
+		for i, cc := range clauses {
+			for _, e := range cc.List {
+				next := v.Function.NewBlock()
+				v.Builder.BranchIf(sv.CaseCondition(tag, e), bodies[i], next)
+				next.Seal()
+				v.Builder.SetInsertionPoint(next)
+			}
+		}
+		v.Builder.Branch(def)
+	}
+
+	v.PushTarget(BranchTarget{label, exit, nil})
+	for i, cc := range clauses {
+		// reached by the tests and the fallthrough
+		// of the previous clause, if any
+		bodies[i].Seal()
+		v.Builder.SetInsertionPoint(bodies[i])
+		next := exit
+		stmts := cc.Body
+		if k := len(stmts); k > 0 {
+			if b, ok := stmts[k-1].(*ast.BranchStmt); ok && b.Tok == token.FALLTHROUGH {
+				if i == len(clauses)-1 {
+					util.Perrorf("cannot fallthrough final case in switch")
+				}
+				next, stmts = bodies[i+1], stmts[:k-1]
+			}
+		}
+		cv := &BlockVisitor{NewScope(&sv.Scope), v.FunctionVisitor}
+		for _, s := range stmts {
+			Walk(cv, s)
+		}
+		v.Builder.Branch(next)
+	}
+	v.PopTarget()
+	exit.Seal()
+	v.Builder.SetInsertionPoint(exit)
+}
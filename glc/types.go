@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/types"
 	"goal/lovm"
 	"goal/util"
+	"strings"
 )
 
 var (
@@ -18,15 +21,18 @@ var (
 	Int16  = PrimitiveType{"int16", true, lovm.IntType(16)}
 	Int32  = PrimitiveType{"int32", true, lovm.IntType(32)}
 	Int64  = PrimitiveType{"int64", true, lovm.IntType(64)}
-	Uint   = PrimitiveType{"int", false, lovm.IntType(32)}
-	Uint8  = PrimitiveType{"int8", false, lovm.IntType(8)}
-	Uint16 = PrimitiveType{"int16", false, lovm.IntType(16)}
-	Uint32 = PrimitiveType{"int32", false, lovm.IntType(32)}
-	Uint64 = PrimitiveType{"int64", false, lovm.IntType(64)}
+	Uint   = PrimitiveType{"uint", false, lovm.IntType(32)}
+	Uint8  = PrimitiveType{"uint8", false, lovm.IntType(8)}
+	Uint16 = PrimitiveType{"uint16", false, lovm.IntType(16)}
+	Uint32 = PrimitiveType{"uint32", false, lovm.IntType(32)}
+	Uint64 = PrimitiveType{"uint64", false, lovm.IntType(64)}
 	Bool   = PrimitiveType{"bool", false, lovm.IntType(1)}
-	String = PrimitiveType{"string", false, lovm.PointerType(lovm.IntType(8))}
-	// TODO(mkm): should be an interface type
-	Error = PrimitiveType{"error", false, lovm.PointerType(lovm.IntType(8))}
+	// strings are { i8*, int } headers, see strings.go
+	String = PrimitiveType{"string", false, lovm.StructType([]lovm.Type{BytePtr, Int.LlvmType()}, false)}
+	// the predeclared error interface
+	Error = &NamedType{Name: "error", underlying: &InterfaceType{[]IMethod{
+		{"Error", FunctionType{Results: []Symbol{{Type: String}}}},
+	}}}
 )
 
 var (
@@ -43,7 +49,6 @@ var (
 		Uint64,
 		Bool,
 		String,
-		Error,
 	}
 	primitiveTypeByName = make(map[string]Type)
 )
@@ -52,6 +57,9 @@ func init() {
 	for _, t := range primitiveTypes {
 		primitiveTypeByName[t.Name] = t
 	}
+	primitiveTypeByName["byte"] = Uint8
+	primitiveTypeByName["rune"] = Int32
+	primitiveTypeByName["error"] = Error
 }
 
 type Type interface {
@@ -96,19 +104,366 @@ func (b MapType) LlvmType() lovm.Type {
 	return lovm.PointerType(lovm.IntType(8))
 }
 
-type SliceType struct {
-	Value Type
+func (b MapType) String() string {
+	return fmt.Sprintf("Type(map[%v]%v)", b.Key, b.Value)
 }
 
-func (b SliceType) LlvmType() lovm.Type {
-	// TODO(mkm) use struct type
+// Channels are lowered to pointers to channels allocated
+// by the runtime, null for nil channels, see chans.go.
+type ChanType struct {
+	Elem Type
+	// ast.SEND, ast.RECV or both
+	Dir ast.ChanDir
+}
+
+func (t ChanType) LlvmType() lovm.Type {
 	return lovm.PointerType(lovm.IntType(8))
 }
 
+func (t ChanType) String() string {
+	return fmt.Sprintf("Type(%s)", TypeString(t))
+}
+
+type SliceType struct {
+	Elem Type
+}
+
+func (t SliceType) LlvmType() lovm.Type {
+	return lovm.StructType([]lovm.Type{lovm.PointerType(t.Elem.LlvmType()), Int.LlvmType(), Int.LlvmType()}, false)
+}
+
+func (t SliceType) String() string {
+	return fmt.Sprintf("Type([]%v)", t.Elem)
+}
+
+// A NamedType is a type introduced by a type declaration. Its
+// underlying type is resolved lazily, so that types and constants
+// can be declared in any order.
+type NamedType struct {
+	Name string
+	// empty for predeclared types
+	Package    string
+	Spec       *ast.TypeSpec
+	Scope      *Scope
+	underlying Type
+	resolving  bool
+	llvmType   lovm.Type
+	// declared methods, see methods.go
+	Methods map[string]*Method
+}
+
+// Underlying returns the type the named type is defined as.
+func (t *NamedType) Underlying() Type {
+	if t.underlying == nil {
+		if t.resolving {
+			util.Perrorf("invalid recursive type %s", t.Name)
+		}
+		t.resolving = true
+		t.underlying = Underlying(t.Scope.ParseType(t.Spec.Type))
+		t.resolving = false
+
+		if named, ok := t.llvmType.(*lovm.NamedType); ok {
+			st := t.underlying.(StructType)
+			named.SetBody(TypesToLlvmTypes(st.FieldTypes()), false)
+		}
+	}
+	return t.underlying
+}
+
+// Struct types are lowered to an identified llvm type, which
+// is created upfront so that they can refer to themselves.
+func (t *NamedType) LlvmType() lovm.Type {
+	if t.llvmType == nil {
+		t.llvmType = t.Underlying().LlvmType()
+	}
+	return t.llvmType
+}
+
+func (t *NamedType) String() string {
+	return fmt.Sprintf("Type(%s)", t.Name)
+}
+
+// Underlying returns the underlying type of named types,
+// and the type itself otherwise.
+func Underlying(t Type) Type {
+	if n, ok := t.(*NamedType); ok {
+		return n.Underlying()
+	}
+	return t
+}
+
+type Field struct {
+	Name     string
+	Type     Type
+	Embedded bool
+}
+
+type StructType struct {
+	Fields []Field
+}
+
+func (t StructType) FieldTypes() (res []Type) {
+	for _, f := range t.Fields {
+		res = append(res, f.Type)
+	}
+	return
+}
+
+func (t StructType) LlvmType() lovm.Type {
+	return lovm.StructType(TypesToLlvmTypes(t.FieldTypes()), false)
+}
+
+func (t StructType) String() string {
+	fields := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		fields[i] = fmt.Sprintf("%s %v", f.Name, f.Type)
+	}
+	return fmt.Sprintf("Type(struct{%s})", strings.Join(fields, "; "))
+}
+
+// FieldByName returns the index and the field of a struct type.
+func FieldByName(t Type, name string) (int, Field) {
+	st, ok := Underlying(t).(StructType)
+	if ok {
+		for i, f := range st.Fields {
+			if f.Name == name {
+				return i, f
+			}
+		}
+	}
+	util.Perrorf("%v has no field or method %s", t, name)
+	return 0, Field{}
+}
+
+type PointerType struct {
+	Elem Type
+}
+
+func (t PointerType) LlvmType() lovm.Type {
+	return lovm.PointerType(t.Elem.LlvmType())
+}
+
+func (t PointerType) String() string {
+	return fmt.Sprintf("Type(*%v)", t.Elem)
+}
+
+type ArrayType struct {
+	Len  int
+	Elem Type
+}
+
+func (t ArrayType) LlvmType() lovm.Type {
+	return lovm.ArrayType(t.Elem.LlvmType(), t.Len)
+}
+
+func (t ArrayType) String() string {
+	return fmt.Sprintf("Type([%d]%v)", t.Len, t.Elem)
+}
+
+// TupleType is the type of a call to a
+// function with multiple results.
+type TupleType struct {
+	Types []Type
+}
+
+func (t TupleType) LlvmType() lovm.Type {
+	return lovm.StructType(TypesToLlvmTypes(t.Types), false)
+}
+
+// An InterfaceType is lowered to an { itab, data } pair,
+// see interfaces.go.
+type InterfaceType struct {
+	// sorted by name
+	Methods []IMethod
+}
+
+// An IMethod is a method of an interface type.
+type IMethod struct {
+	Name string
+	Type FunctionType
+}
+
+func (t *InterfaceType) LlvmType() lovm.Type {
+	return lovm.StructType([]lovm.Type{BytePtr, BytePtr}, false)
+}
+
+func (t *InterfaceType) String() string {
+	return fmt.Sprintf("Type(%s)", TypeString(t))
+}
+
+// MethodIndex returns the index of a method of the
+// interface, or -1 if there is no such method.
+func (t *InterfaceType) MethodIndex(name string) int {
+	for i, m := range t.Methods {
+		if m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Function values are lowered to { fnptr, env } closures, see
+// closures.go, while FuncType is the type of the llvm functions.
 type FunctionType struct {
 	Params  []Symbol
 	Results []Symbol
-	// TODO(mkm) receivers
+}
+
+// Identical returns true if a and b are the same type.
+func Identical(a, b Type) bool {
+	switch x := a.(type) {
+	case FunctionType:
+		y, ok := b.(FunctionType)
+		return ok && identicalSymbolTypes(x.Params, y.Params) && identicalSymbolTypes(x.Results, y.Results)
+	case TupleType:
+		y, ok := b.(TupleType)
+		return ok && identicalTypes(x.Types, y.Types)
+	case PointerType:
+		y, ok := b.(PointerType)
+		return ok && Identical(x.Elem, y.Elem)
+	case ArrayType:
+		y, ok := b.(ArrayType)
+		return ok && x.Len == y.Len && Identical(x.Elem, y.Elem)
+	case SliceType:
+		y, ok := b.(SliceType)
+		return ok && Identical(x.Elem, y.Elem)
+	case MapType:
+		y, ok := b.(MapType)
+		return ok && Identical(x.Key, y.Key) && Identical(x.Value, y.Value)
+	case ChanType:
+		y, ok := b.(ChanType)
+		return ok && x.Dir == y.Dir && Identical(x.Elem, y.Elem)
+	case *InterfaceType:
+		y, ok := b.(*InterfaceType)
+		if !ok || len(x.Methods) != len(y.Methods) {
+			return false
+		}
+		for i, m := range x.Methods {
+			if m.Name != y.Methods[i].Name || !Identical(m.Type, y.Methods[i].Type) {
+				return false
+			}
+		}
+		return true
+	case StructType:
+		y, ok := b.(StructType)
+		if !ok || len(x.Fields) != len(y.Fields) {
+			return false
+		}
+		for i, f := range x.Fields {
+			g := y.Fields[i]
+			if f.Name != g.Name || f.Embedded != g.Embedded || !Identical(f.Type, g.Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func identicalSymbolTypes(a, b []Symbol) bool {
+	return identicalTypes(SymbolTypes(a), SymbolTypes(b))
+}
+
+func identicalTypes(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Identical(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func SymbolTypes(ss []Symbol) (res []Type) {
+	for _, s := range ss {
+		res = append(res, s.Type)
+	}
+	return
+}
+
+// ZeroValue returns the value of an uninitialized variable of type typ.
+func ZeroValue(typ Type) lovm.Value {
+	switch t := Underlying(typ).(type) {
+	case PrimitiveType:
+		if t != String {
+			return lovm.ConstInt(typ.LlvmType(), 0)
+		}
+	case PointerType, MapType, ChanType:
+		return lovm.ConstNull(typ.LlvmType())
+	}
+	return lovm.ConstZero(typ.LlvmType())
+}
+
+// Comparable returns true if values of type t can be compared
+// with ==. Slices can only be compared to nil.
+func Comparable(t Type) bool {
+	switch u := Underlying(t).(type) {
+	case SliceType, MapType, FunctionType:
+		return false
+	case StructType:
+		for _, f := range u.Fields {
+			if !Comparable(f.Type) {
+				return false
+			}
+		}
+	case ArrayType:
+		return Comparable(u.Elem)
+	}
+	return true
+}
+
+// Sizeof returns the size in bytes of values of type t,
+// laying out structs like C does.
+func Sizeof(t Type) int {
+	switch u := Underlying(t).(type) {
+	case PrimitiveType:
+		if u == String {
+			return alignTo(PointerSize+Sizeof(Int), PointerSize)
+		}
+		return (IntegerBits(u) + 7) / 8
+	case StructType:
+		size := 0
+		for _, f := range u.Fields {
+			size = alignTo(size, Alignof(f.Type)) + Sizeof(f.Type)
+		}
+		return alignTo(size, Alignof(u))
+	case ArrayType:
+		return u.Len * Sizeof(u.Elem)
+	case SliceType:
+		return PointerSize + 2*Sizeof(Int)
+	case *InterfaceType, FunctionType:
+		return 2 * PointerSize
+	case AnyType:
+		return 0
+	}
+	return PointerSize
+}
+
+// Alignof returns the alignment in bytes of values of type t.
+func Alignof(t Type) int {
+	switch u := Underlying(t).(type) {
+	case StructType:
+		align := 1
+		for _, f := range u.Fields {
+			if a := Alignof(f.Type); a > align {
+				align = a
+			}
+		}
+		return align
+	case ArrayType:
+		return Alignof(u.Elem)
+	}
+	if size := Sizeof(t); size < PointerSize {
+		return size
+	}
+	return PointerSize
+}
+
+func alignTo(offset, align int) int {
+	return (offset + align - 1) / align * align
 }
 
 func (s *Scope) ParseType(typeName ast.Expr) Type {
@@ -122,6 +477,12 @@ func (s *Scope) ParseType(typeName ast.Expr) Type {
 func (s *Scope) ResolveType(typeName ast.Expr) (Type, error) {
 	switch t := typeName.(type) {
 	case *ast.Ident:
+		if sym, ok := s.LookupSymbol(t.Name); ok {
+			if !sym.TypeName {
+				return nil, fmt.Errorf("%s is not a type", t.Name)
+			}
+			return sym.Type, nil
+		}
 		if primitive, ok := primitiveTypeByName[t.Name]; ok {
 			return primitive, nil
 		} else {
@@ -130,11 +491,55 @@ func (s *Scope) ResolveType(typeName ast.Expr) (Type, error) {
 	case *ast.SelectorExpr:
 		return nil, fmt.Errorf("NOT IMPLEMENTED YET: qualified type names")
 	case *ast.MapType:
-		return nil, fmt.Errorf("NOT IMPLEMENTED YET: map type")
+		key, err := s.ResolveType(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !Comparable(key) {
+			return nil, fmt.Errorf("invalid map key type %s", types.ExprString(t.Key))
+		}
+		value, err := s.ResolveType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return MapType{key, value}, nil
 	case *ast.ArrayType:
-		return nil, fmt.Errorf("NOT IMPLEMENTED YET: array type")
+		if _, ok := t.Len.(*ast.Ellipsis); ok {
+			return nil, fmt.Errorf("invalid use of [...] array (outside a composite literal)")
+		}
+		elem, err := s.ResolveType(t.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if t.Len == nil {
+			return SliceType{elem}, nil
+		}
+		c, ok := s.ConstValue(t.Len)
+		if !ok || !IsInteger(c.Type) || !Representable(c.Value, Int) || constant.Sign(c.Value) < 0 {
+			return nil, fmt.Errorf("invalid array length %s", types.ExprString(t.Len))
+		}
+		n, _ := constant.Int64Val(c.Value)
+		return ArrayType{int(n), elem}, nil
+	case *ast.StructType:
+		return s.ResolveStructType(t)
+	case *ast.StarExpr:
+		elem, err := s.ResolveType(t.X)
+		if err != nil {
+			return nil, err
+		}
+		return PointerType{elem}, nil
+	case *ast.ParenExpr:
+		return s.ResolveType(t.X)
+	case *ast.InterfaceType:
+		return s.ResolveInterfaceType(t)
+	case *ast.FuncType:
+		return s.ParseFuncType(t), nil
 	case *ast.ChanType:
-		return nil, fmt.Errorf("NOT IMPLEMENTED YET: chan type")
+		elem, err := s.ResolveType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return ChanType{elem, t.Dir}, nil
 	default:
 		return nil, fmt.Errorf("runtime error: unknown type class: %#v", typeName)
 	}
@@ -161,6 +566,21 @@ func (s *Scope) ParseSymbols(fl *ast.FieldList) (res []Symbol) {
 	return
 }
 
+// FieldIdents returns the name of each symbol declared by a field
+// list, in the order of ParseSymbols. Unnamed symbols have a nil name.
+func FieldIdents(fl *ast.FieldList) (res []*ast.Ident) {
+	if fl == nil {
+		return nil
+	}
+	for _, f := range fl.List {
+		if f.Names == nil {
+			res = append(res, nil)
+		}
+		res = append(res, f.Names...)
+	}
+	return
+}
+
 func (s *Scope) ParseTypes(fl *ast.FieldList) (types []Type) {
 	for _, s := range s.ParseSymbols(fl) {
 		types = append(types, s.Type)
@@ -176,6 +596,16 @@ func (s *Scope) ParseFuncType(ft *ast.FuncType) FunctionType {
 }
 
 func (t FunctionType) LlvmType() lovm.Type {
+	return lovm.StructType([]lovm.Type{BytePtr, BytePtr}, false)
+}
+
+func (t FunctionType) String() string {
+	return fmt.Sprintf("Type(%s)", TypeString(t))
+}
+
+// FuncType returns the type of the llvm functions
+// implementing functions of type t.
+func (t FunctionType) FuncType() *lovm.FuncType {
 	func_arg_types := SymbolsToLlvmTypes(t.Params)
 	func_ret_types := SymbolsToLlvmTypes(t.Results)
 
@@ -186,8 +616,7 @@ func (t FunctionType) LlvmType() lovm.Type {
 	case 1:
 		func_ret_type = func_ret_types[0]
 	default:
-		util.Perrorf("not migrated yet to lovm")
-		//func_ret_type = lovm.StructType(func_ret_types, false)
+		func_ret_type = lovm.StructType(func_ret_types, false)
 	}
 	return lovm.FunctionType(func_ret_type, false, func_arg_types...)
 }
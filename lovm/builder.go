@@ -5,7 +5,9 @@ type Adder interface {
 	Assign(Register, Value) Value
 	Branch(*Block)
 	BranchIf(value Value, ifTrue, ifFalse *Block)
+	Switch(value Value, def *Block, cases []Value, targets []*Block)
 	Return(Value)
+	Unreachable()
 }
 
 type Builder struct {
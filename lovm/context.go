@@ -1,8 +1,10 @@
 package lovm
 
 import (
+	"fmt"
 	"goal/util"
 	"io"
+	"strings"
 )
 
 type Context struct {
@@ -19,6 +21,16 @@ func NewContext(w io.Writer) Context {
 type External struct {
 	Name string
 	Type Type
+	// function attributes, as in returns_twice
+	Attrs []string
+}
+
+func (e External) Emit(w io.Writer) {
+	if len(e.Attrs) == 0 {
+		e.Type.EmitDecl(w, e.Name)
+		return
+	}
+	fmt.Fprintf(w, "declare %s %s\n", e.Type.(*FuncType).funcDecl(e.Name), strings.Join(e.Attrs, " "))
 }
 
 type Global struct {
@@ -32,19 +44,43 @@ type Constant interface {
 	Emit(w io.Writer)
 }
 
+// ValueInitializer initializes a global with a constant value.
+type ValueInitializer struct {
+	Value Value
+}
+
+func (v ValueInitializer) Emit(w io.Writer) {
+	io.WriteString(w, v.Value.Name())
+}
+
+func (g Global) Emit(w io.Writer) {
+	attrs := ""
+	if len(g.Attrs) > 0 {
+		attrs = strings.Join(g.Attrs, " ") + " "
+	}
+	fmt.Fprintf(w, "%s = %sglobal %s ", g.Name, attrs, g.Type.Name())
+	g.Init.Emit(w)
+	fmt.Fprintf(w, "\n")
+}
+
 type Module struct {
 	*Context
 	Name      string
 	Functions []*Function
 	Externals []External
 	Globals   []Global
+	TypeDefs  []*NamedType
 	Interned  util.Sequence
+	Strings   map[string]Value
+	// functions run before main
+	Constructors []string
 }
 
 func (ctx *Context) NewModule(name string) *Module {
 	mod := &Module{
 		Context: ctx,
 		Name:    name,
+		Strings: map[string]Value{},
 	}
 
 	ctx.AddModule(mod)
@@ -62,22 +98,82 @@ func (ctx *Context) Emit() {
 }
 
 func (mod *Module) DeclareExternal(name string, signature Type) SymRef {
-	mod.Externals = append(mod.Externals, External{name, signature})
+	mod.Externals = append(mod.Externals, External{name, signature, nil})
 	return SymRef{name, PointerType(signature)}
 }
 
+// DeclareExternalAttrs declares an external function
+// with the given function attributes.
+func (mod *Module) DeclareExternalAttrs(name string, signature *FuncType, attrs ...string) SymRef {
+	mod.Externals = append(mod.Externals, External{name, signature, attrs})
+	return SymRef{name, PointerType(signature)}
+}
+
+// QuoteIdent quotes a symbol name if it contains characters
+// not allowed in plain llvm identifiers.
+func QuoteIdent(name string) string {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-$._", c)) {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	return name
+}
+
+// NewNamedType defines an identified structure type. The type is
+// opaque until its body is set.
+func (mod *Module) NewNamedType(name string) *NamedType {
+	typ := &NamedType{name: fmt.Sprintf("%%%s", QuoteIdent(name))}
+	mod.TypeDefs = append(mod.TypeDefs, typ)
+	return typ
+}
+
+// NewGlobal defines a global variable and returns its address.
+func (mod *Module) NewGlobal(name string, typ Type, init Constant) SymRef {
+	name = fmt.Sprintf("@%s", name)
+	mod.Globals = append(mod.Globals, Global{Name: name, Type: typ, Init: init})
+	return SymRef{name, PointerType(typ)}
+}
+
+// AddConstructor registers a function to be run at startup.
+// The function must have type void ().
+func (mod *Module) AddConstructor(name string) {
+	mod.Constructors = append(mod.Constructors, name)
+}
+
+type constructorsInitializer []string
+
+func (c constructorsInitializer) Emit(w io.Writer) {
+	entries := make([]string, len(c))
+	for i, name := range c {
+		entries[i] = fmt.Sprintf("{ i32, void ()*, i8* } { i32 65535, void ()* @%s, i8* null }", name)
+	}
+	fmt.Fprintf(w, "[%s]", strings.Join(entries, ", "))
+}
+
 func (mod *Module) AddFunction(f *Function) {
 	mod.Functions = append(mod.Functions, f)
 }
 
 func (mod *Module) Emit() {
+	for _, t := range mod.TypeDefs {
+		t.EmitTypeDef(mod.Writer)
+	}
 	for _, e := range mod.Externals {
-		e.Type.EmitDecl(mod.Writer, e.Name)
+		e.Emit(mod.Writer)
 	}
 	for _, g := range mod.Globals {
-		g.Type.EmitDef(mod.Writer, g.Name, func() {
-			g.Init.Emit(mod.Writer)
-		})
+		g.Emit(mod.Writer)
+	}
+	if len(mod.Constructors) > 0 {
+		entry := StructType([]Type{IntType(32), PointerType(FunctionType(VoidType(), false)), PointerType(IntType(8))}, false)
+		ctors := Global{
+			Name:  "@llvm.global_ctors",
+			Type:  ArrayType(entry, len(mod.Constructors)),
+			Attrs: []string{"appending"},
+			Init:  constructorsInitializer(mod.Constructors),
+		}
+		ctors.Emit(mod.Writer)
 	}
 	for _, f := range mod.Functions {
 		f.Emit()
@@ -12,15 +12,16 @@ type Function struct {
 	Tmps   util.Sequence
 	Labels util.Sequence
 
-	Blocks []*Block
-	Values map[Value]bool
-	Params []*Param
-	Type   FuncType
-	Name   string
+	Blocks  []*Block
+	Allocas []Value
+	Values  map[Value]bool
+	Params  []*Param
+	Type    *FuncType
+	Name    string
 }
 
 func (mod *Module) NewFunction(name string, typ Type) *Function {
-	signature := typ.(FuncType)
+	signature := typ.(*FuncType)
 	fun := &Function{
 		Module: mod,
 		Values: map[Value]bool{},
@@ -45,6 +46,19 @@ func (fun *Function) NewBlock() *Block {
 	return res
 }
 
+// AddAlloca registers a stack allocation, which will be
+// emitted at the beginning of the entry block.
+func (fun *Function) AddAlloca(alloca *AllocaOp) Value {
+	fun.Allocas = append(fun.Allocas, alloca)
+	fun.Values[alloca] = true
+	return alloca
+}
+
+// Ref returns the address of the function.
+func (fun *Function) Ref() Value {
+	return SymRef{"@" + fun.Name, PointerType(fun.Type)}
+}
+
 func (fun *Function) Param(idx int) Value {
 	return fun.Params[idx]
 }
@@ -56,6 +70,17 @@ func (fun *Function) Emitf(format string, args ...interface{}) {
 }
 
 func (fun *Function) Emit() {
+	for _, b := range fun.Blocks {
+		b.Seal()
+		if !b.Terminated() {
+			b.Unreachable()
+		}
+	}
+	if len(fun.Allocas) > 0 {
+		entry := fun.Blocks[0]
+		entry.Values = append(fun.Allocas, entry.Values...)
+		fun.Allocas = nil
+	}
 	for _, b := range fun.Blocks {
 		b.Prepare(fun)
 	}
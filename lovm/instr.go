@@ -6,8 +6,16 @@ import (
 )
 
 const (
+	IntEQ  = "eq"
+	IntNE  = "ne"
 	IntSLT = "slt"
+	IntSLE = "sle"
 	IntSGT = "sgt"
+	IntSGE = "sge"
+	IntULT = "ult"
+	IntULE = "ule"
+	IntUGE = "uge"
+	IntUGT = "ugt"
 )
 
 func (b *Builder) IAdd(op1, op2 Value) Value {
@@ -35,6 +43,51 @@ func (b *Builder) ISRem(op1, op2 Value) Value {
 	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "srem", op1, op2})
 }
 
+func (b *Builder) IUDiv(op1, op2 Value) Value {
+	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
+	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "udiv", op1, op2})
+}
+
+func (b *Builder) IURem(op1, op2 Value) Value {
+	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
+	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "urem", op1, op2})
+}
+
+func (b *Builder) And(op1, op2 Value) Value {
+	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
+	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "and", op1, op2})
+}
+
+func (b *Builder) Or(op1, op2 Value) Value {
+	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
+	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "or", op1, op2})
+}
+
+func (b *Builder) Xor(op1, op2 Value) Value {
+	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
+	return b.Add(&Binop{Valuable{Typ: op1.Type()}, "xor", op1, op2})
+}
+
+func (b *Builder) Trunc(value Value, typ Type) Value {
+	util.AssertNotNil(value, typ)
+	return b.Add(&CastOp{Valuable{Typ: typ}, "trunc", value})
+}
+
+func (b *Builder) SExt(value Value, typ Type) Value {
+	util.AssertNotNil(value, typ)
+	return b.Add(&CastOp{Valuable{Typ: typ}, "sext", value})
+}
+
+func (b *Builder) ZExt(value Value, typ Type) Value {
+	util.AssertNotNil(value, typ)
+	return b.Add(&CastOp{Valuable{Typ: typ}, "zext", value})
+}
+
+func (b *Builder) BitCast(value Value, typ Type) Value {
+	util.AssertNotNil(value, typ)
+	return b.Add(&CastOp{Valuable{Typ: typ}, "bitcast", value})
+}
+
 func (b *Builder) IICmp(op string, op1, op2 Value) Value {
 	util.AssertNotNil(op1, op2, op1.Type(), op2.Type())
 	return b.Add(&Binop{Valuable{Typ: IntType(1)}, fmt.Sprintf("icmp %s", op), op1, op2})
@@ -42,15 +95,63 @@ func (b *Builder) IICmp(op string, op1, op2 Value) Value {
 
 func (b *Builder) Ref(typ Type, sym Register) Value {
 	util.AssertNotNil(typ)
-	return b.Add(&RefOp{Valuable{Typ: typ}, sym, ""})
+	value := b.GetInsertBlock().ReadVar(typ, sym)
+	return b.Add(&RefOp{Valuable{Typ: typ}, sym, value})
 }
 
 func (b *Builder) Call(typ Type, fun string, args ...Value) Value {
 	util.AssertNotNil(typ)
-	return b.Add(&CallOp{Valuable{Typ: typ}, fun, args})
+	return b.Add(&CallOp{Valuable{Typ: typ}, fun, nil, args})
+}
+
+// CallIndirect calls the function pointed to by fun.
+func (b *Builder) CallIndirect(typ Type, fun Value, args ...Value) Value {
+	util.AssertNotNil(typ)
+	return b.Add(&CallOp{Valuable{Typ: typ}, "", fun, args})
 }
 
-func (b *Builder) GEP(base Value, indices ...int) Value {
+// GEP computes the address of an element of the aggregate pointed
+// to by base. Indices into structures must be constants.
+func (b *Builder) GEP(base Value, indices ...Value) Value {
 	util.AssertNotNil(base)
 	return b.Add(&GEPOp{Valuable{Typ: DereferenceTypes(base.Type(), indices...)}, base, indices})
 }
+
+func (b *Builder) ExtractValue(agg Value, indices ...int) Value {
+	util.AssertNotNil(agg)
+	return b.Add(&ExtractValueOp{Valuable{Typ: ElementType(agg.Type(), indices...)}, agg, indices})
+}
+
+func (b *Builder) InsertValue(agg, elem Value, indices ...int) Value {
+	util.AssertNotNil(agg, elem)
+	return b.Add(&InsertValueOp{Valuable{Typ: agg.Type()}, agg, elem, indices})
+}
+
+// Alloca returns a pointer to a new stack slot of type typ,
+// allocated in the entry block of the current function.
+// A zero align uses the ABI alignment of the type.
+func (b *Builder) Alloca(typ Type, align int) Value {
+	util.AssertNotNil(typ)
+	return b.GetInsertBlock().Function.AddAlloca(&AllocaOp{Valuable{Typ: PointerType(typ)}, typ, align})
+}
+
+func (b *Builder) Load(ptr Value) Value {
+	return b.LoadAttrs(ptr, MemoryAttrs{})
+}
+
+func (b *Builder) LoadAttrs(ptr Value, attrs MemoryAttrs) Value {
+	util.AssertNotNil(ptr)
+	return b.Add(&LoadOp{Valuable{Typ: ptr.Type().Dereference()}, ptr, attrs})
+}
+
+func (b *Builder) Store(value, ptr Value) {
+	b.StoreAttrs(value, ptr, MemoryAttrs{})
+}
+
+func (b *Builder) StoreAttrs(value, ptr Value, attrs MemoryAttrs) {
+	util.AssertNotNil(value, ptr)
+	if !SameType(value.Type(), ptr.Type().Dereference()) {
+		panic(fmt.Errorf("cannot store %s into %s", value.Type().Name(), ptr.Type().Name()))
+	}
+	b.Add(&StoreOp{value, ptr, attrs})
+}
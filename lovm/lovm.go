@@ -11,11 +11,14 @@ import (
 
 type Block struct {
 	Labelable
-	Phis     []Value
+	Phis     []*PhiOp
 	Values   []Value
 	Preds    []*Block
 	Vars     map[Register]Value
 	Function *Function
+	// see ssa.go
+	Sealed     bool
+	Incomplete []*PhiOp
 }
 
 type Emitter interface {
@@ -64,6 +67,13 @@ type Binop struct {
 	Op2   Value
 }
 
+// A CastOp converts a value to another type.
+type CastOp struct {
+	Valuable
+	Instr string
+	Value Value
+}
+
 type BranchOp struct {
 	Labels []*Block
 }
@@ -73,6 +83,14 @@ type BranchIfOp struct {
 	Cond Value
 }
 
+// A SwitchOp jumps to the target of the case equal to the value,
+// or to the default target, the first of its labels.
+type SwitchOp struct {
+	BranchOp
+	Value Value
+	Cases []Value
+}
+
 type ReturnOp struct {
 	Valuable
 	Result Value
@@ -80,16 +98,60 @@ type ReturnOp struct {
 
 type CallOp struct {
 	Valuable
-	Fun  string
-	Args []Value
+	Fun string
+	// function pointer of indirect calls, which have no Fun
+	Callee Value
+	Args   []Value
 }
 
 type GEPOp struct {
 	Valuable
 	Base    Value
+	Indices []Value
+}
+
+type ExtractValueOp struct {
+	Valuable
+	Agg     Value
+	Indices []int
+}
+
+type InsertValueOp struct {
+	Valuable
+	Agg     Value
+	Elem    Value
 	Indices []int
 }
 
+// MemoryAttrs are the optional attributes of a memory access.
+// A zero Align lets llvm pick the ABI alignment of the type.
+type MemoryAttrs struct {
+	Align    int
+	Volatile bool
+}
+
+// An AllocaOp reserves stack space for a value of type Elem.
+// Allocas are always placed in the entry block of the function,
+// so that they are executed once per call and can be promoted
+// to registers by llvm.
+type AllocaOp struct {
+	Valuable
+	Elem  Type
+	Align int
+}
+
+type LoadOp struct {
+	Valuable
+	Ptr   Value
+	Attrs MemoryAttrs
+}
+
+type StoreOp struct {
+	Value Value
+	Ptr   Value
+	Attrs MemoryAttrs
+}
+
 type Param struct {
 	Valuable
 }
@@ -99,20 +161,30 @@ type Const struct {
 	Val string
 }
 
+type UnreachableOp struct {
+}
+
+// A RefOp is a read of a variable. The variable is resolved
+// to its reaching definition when the ref is built.
 type RefOp struct {
 	Valuable
 	Sym   Register
-	Alias string
+	Value Value
 }
 
 type PhiParam struct {
-	Value string
-	Label string
+	Value Value
+	Block *Block
 }
 
 type PhiOp struct {
-	RefOp
+	Valuable
+	Sym  Register
 	Phis []PhiParam
+	// phis using this phi as an operand
+	Users []*PhiOp
+	// set when the phi is found to be trivial
+	Replacement Value
 }
 
 // a symbol ref is just a name
@@ -140,21 +212,94 @@ func (s SymRef) Prepare(*Function, *Block) {
 	// no preparation needed for symref
 }
 
+func (b *CallOp) Prepare(fun *Function, blk *Block) {
+	// calls to void functions are not named
+	if b.Typ != VoidType() {
+		b.Valuable.Prepare(fun, blk)
+	}
+}
+
 func (b *CallOp) Emit(fun *Function) {
 	args := []string{}
 	for _, a := range b.Args {
 		args = append(args, fmt.Sprintf("%s %s", a.Type().Name(), a.Name()))
 	}
-	fun.Emitf("%s = call %s @%s(%s)", b.Name(), b.Typ.Name(), b.Fun, strings.Join(args, ", "))
+	callee := "@" + b.Fun
+	if b.Callee != nil {
+		callee = b.Callee.Name()
+	}
+	if b.Typ == VoidType() {
+		fun.Emitf("call void %s(%s)", callee, strings.Join(args, ", "))
+		return
+	}
+	fun.Emitf("%s = call %s %s(%s)", b.Name(), b.Typ.Name(), callee, strings.Join(args, ", "))
 }
 
 func (b *GEPOp) Emit(fun *Function) {
 	args := []string{}
 	for _, i := range b.Indices {
-		args = append(args, fmt.Sprintf("i64 %d", i))
+		args = append(args, fmt.Sprintf("%s %s", i.Type().Name(), i.Name()))
 	}
 
-	fun.Emitf("%s = getelementptr %s %s, %s", b.Name(), b.Base.Type().Name(), b.Base.Name(), strings.Join(args, ", "))
+	fun.Emitf("%s = getelementptr %s, %s %s, %s", b.Name(), b.Base.Type().Dereference().Name(),
+		b.Base.Type().Name(), b.Base.Name(), strings.Join(args, ", "))
+}
+
+func joinIndices(indices []int) string {
+	res := make([]string, len(indices))
+	for i, idx := range indices {
+		res[i] = fmt.Sprintf("%d", idx)
+	}
+	return strings.Join(res, ", ")
+}
+
+func (b *ExtractValueOp) Emit(fun *Function) {
+	fun.Emitf("%s = extractvalue %s %s, %s", b.Name(), b.Agg.Type().Name(), b.Agg.Name(), joinIndices(b.Indices))
+}
+
+func (b *InsertValueOp) Emit(fun *Function) {
+	fun.Emitf("%s = insertvalue %s %s, %s %s, %s", b.Name(), b.Agg.Type().Name(), b.Agg.Name(),
+		b.Elem.Type().Name(), b.Elem.Name(), joinIndices(b.Indices))
+}
+
+func alignSuffix(align int) string {
+	if align == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", align %d", align)
+}
+
+func volatilePrefix(volatile bool) string {
+	if volatile {
+		return "volatile "
+	}
+	return ""
+}
+
+func (b *AllocaOp) Emit(fun *Function) {
+	fun.Emitf("%s = alloca %s%s", b.Name(), b.Elem.Name(), alignSuffix(b.Align))
+}
+
+func (b *LoadOp) Emit(fun *Function) {
+	fun.Emitf("%s = load %s%s, %s %s%s", b.Name(), volatilePrefix(b.Attrs.Volatile), b.Typ.Name(),
+		b.Ptr.Type().Name(), b.Ptr.Name(), alignSuffix(b.Attrs.Align))
+}
+
+func (b *StoreOp) Name() string {
+	log.Fatalf("Store ops should never be named")
+	return ""
+}
+
+func (b *StoreOp) Type() Type {
+	return VoidType()
+}
+
+func (b *StoreOp) Prepare(*Function, *Block) {
+}
+
+func (b *StoreOp) Emit(fun *Function) {
+	fun.Emitf("store %s%s %s, %s %s%s", volatilePrefix(b.Attrs.Volatile), b.Value.Type().Name(), b.Value.Name(),
+		b.Ptr.Type().Name(), b.Ptr.Name(), alignSuffix(b.Attrs.Align))
 }
 
 func (b Param) Emit(*Function) {
@@ -182,34 +327,24 @@ func (b RefOp) Emit(fun *Function) {
 }
 
 func (r *RefOp) Name() string {
-	if r.Alias != "" {
-		return r.Alias
-	}
-	return r.Valuable.Name()
+	return r.Value.Name()
 }
 
 func (r *RefOp) Prepare(fun *Function, b *Block) {
-	phis := []PhiParam{}
-	for _, p := range b.Preds {
-		if v, ok := p.ResolveVar(r.Sym); ok {
-			phis = append(phis, PhiParam{v.Name(), p.Name()})
-		}
-	}
+	// already resolved while building
+}
 
-	if len(phis) > 0 {
-		r.Valuable.Prepare(fun, b)
-		b.Phis = append(b.Phis, &PhiOp{*r, phis})
-	} else {
-		if v, ok := b.ResolveVar(r.Sym); ok {
-			r.Alias = v.Name()
-		}
+func (p *PhiOp) Name() string {
+	if p.Replacement != nil {
+		return p.Replacement.Name()
 	}
+	return p.Valuable.Name()
 }
 
 func (b PhiOp) Emit(fun *Function) {
 	comps := []string{}
 	for _, phi := range b.Phis {
-		comps = append(comps, fmt.Sprintf("[ %s, %s ]", phi.Value, phi.Label))
+		comps = append(comps, fmt.Sprintf("[ %s, %s ]", phi.Value.Name(), phi.Block.Name()))
 	}
 	fun.Emitf("%s = phi %s %s", b.Name(), b.Typ.Name(), strings.Join(comps, ", "))
 }
@@ -218,6 +353,27 @@ func ConstInt(typ Type, value int64) Const {
 	return Const{typ, fmt.Sprintf("%d", value)}
 }
 
+// Indices returns constant indices for getelementptr.
+func Indices(indices ...int) []Value {
+	res := make([]Value, len(indices))
+	for i, idx := range indices {
+		res[i] = ConstInt(IntType(32), int64(idx))
+	}
+	return res
+}
+
+func ConstZero(typ Type) Const {
+	return Const{typ, "zeroinitializer"}
+}
+
+func ConstNull(typ Type) Const {
+	return Const{typ, "null"}
+}
+
+func Undef(typ Type) Const {
+	return Const{typ, "undef"}
+}
+
 func ConstIntFromString(typ Type, value string, base int) Const {
 	num, err := strconv.ParseInt(value, base, 64)
 	if err != nil {
@@ -226,12 +382,67 @@ func ConstIntFromString(typ Type, value string, base int) Const {
 	return ConstInt(typ, num)
 }
 
+// ConstString returns a pointer to a global array holding the bytes
+// of value followed by a NUL byte, so that it can also be passed to
+// C functions. Equal strings share the same global.
 func (mod *Module) ConstString(value string) Value {
+	if ref, ok := mod.Strings[value]; ok {
+		return ref
+	}
 	name := fmt.Sprintf("@.str%d", mod.Interned.Next())
 	typ := ArrayType(IntType(8), len(value)+1)
 	init := StringInitializer{value}
 	mod.Globals = append(mod.Globals, Global{Name: name, Type: typ, Init: init})
-	return SymRef{name, PointerType(typ)}
+	ref := SymRef{name, PointerType(typ)}
+	mod.Strings[value] = ref
+	return ref
+}
+
+// ConstGEP returns the constant address computed by
+// a getelementptr on a constant base.
+func ConstGEP(base Value, indices ...Value) Const {
+	args := []string{}
+	for _, i := range indices {
+		args = append(args, fmt.Sprintf("%s %s", i.Type().Name(), i.Name()))
+	}
+	val := fmt.Sprintf("getelementptr inbounds (%s, %s %s, %s)", base.Type().Dereference().Name(),
+		base.Type().Name(), base.Name(), strings.Join(args, ", "))
+	return Const{DereferenceTypes(base.Type(), indices...), val}
+}
+
+// ConstBitCast returns the constant value converted to typ.
+func ConstBitCast(value Value, typ Type) Const {
+	return Const{typ, fmt.Sprintf("bitcast (%s %s to %s)", value.Type().Name(), value.Name(), typ.Name())}
+}
+
+// ConstArray returns a constant array of elements of type elem.
+func ConstArray(elem Type, elems ...Value) Const {
+	typ := ArrayType(elem, len(elems))
+	if len(elems) == 0 {
+		return ConstZero(typ)
+	}
+	vals := make([]string, len(elems))
+	for i, e := range elems {
+		vals[i] = fmt.Sprintf("%s %s", e.Type().Name(), e.Name())
+	}
+	return Const{typ, fmt.Sprintf("[ %s ]", strings.Join(vals, ", "))}
+}
+
+// ConstStruct returns a constant structure of type typ
+// with the given constant fields.
+func ConstStruct(typ Type, fields ...Value) Const {
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		vals[i] = fmt.Sprintf("%s %s", f.Type().Name(), f.Name())
+	}
+	body := typ
+	if named, ok := typ.(*NamedType); ok && !named.Opaque() {
+		body = named.Body
+	}
+	if s, ok := body.(*StructureType); ok && s.Packed {
+		return Const{typ, fmt.Sprintf("<{ %s }>", strings.Join(vals, ", "))}
+	}
+	return Const{typ, fmt.Sprintf("{ %s }", strings.Join(vals, ", "))}
 }
 
 // TODO(mkm): generalize
@@ -239,8 +450,20 @@ type StringInitializer struct {
 	Value string
 }
 
+// Escape returns the bytes of s in the syntax of llvm string
+// constants, where bytes other than printable ASCII characters,
+// as well as quotes and backslashes, are escaped as \XX hex codes.
 func Escape(s string) string {
-	return strings.Replace(s, "\n", "\\0A", -1)
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&buf, "\\%02X", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
 }
 
 func (s StringInitializer) Emit(w io.Writer) {
@@ -254,6 +477,10 @@ func (b *Binop) Emit(fun *Function) {
 	fun.Emitf("%s = %s %s %s, %s", b.Name(), b.Instr, argType.Name(), b.Op1.Name(), b.Op2.Name())
 }
 
+func (b *CastOp) Emit(fun *Function) {
+	fun.Emitf("%s = %s %s %s to %s", b.Name(), b.Instr, b.Value.Type().Name(), b.Value.Name(), b.Typ.Name())
+}
+
 func (b *BranchOp) Name() string {
 	log.Fatalf("Branch ops should never be named")
 	return ""
@@ -274,10 +501,42 @@ func (b *BranchIfOp) Emit(fun *Function) {
 	fun.Emitf("br i1 %s, label %s, label %s", b.Cond.Name(), b.Labels[0].Name(), b.Labels[1].Name())
 }
 
+func (b *SwitchOp) Emit(fun *Function) {
+	cases := make([]string, len(b.Cases))
+	for i, c := range b.Cases {
+		cases[i] = fmt.Sprintf("%s %s, label %s", c.Type().Name(), c.Name(), b.Labels[i+1].Name())
+	}
+	fun.Emitf("switch %s %s, label %s [ %s ]", b.Value.Type().Name(), b.Value.Name(), b.Labels[0].Name(), strings.Join(cases, " "))
+}
+
+func (b *ReturnOp) Prepare(*Function, *Block) {
+	// returns are never named
+}
+
 func (b *ReturnOp) Emit(fun *Function) {
+	if b.Result == nil {
+		fun.Emitf("ret void")
+		return
+	}
 	fun.Emitf("ret %s %s", b.Typ.Name(), b.Result.Name())
 }
 
+func (b *UnreachableOp) Name() string {
+	log.Fatalf("Unreachable ops should never be named")
+	return ""
+}
+
+func (b *UnreachableOp) Type() Type {
+	return VoidType()
+}
+
+func (b *UnreachableOp) Prepare(*Function, *Block) {
+}
+
+func (b *UnreachableOp) Emit(fun *Function) {
+	fun.Emitf("unreachable")
+}
+
 func (b *Block) Add(value Value) Value {
 	if !b.Function.Values[value] {
 		b.Values = append(b.Values, value)
@@ -286,21 +545,50 @@ func (b *Block) Add(value Value) Value {
 	return value
 }
 
-func (b *Block) ResolveVar(symbol Register) (Value, bool) {
-	if v, ok := b.Vars[symbol]; ok {
-		return v, ok
+// Terminated returns true if the block already ends with
+// a terminator instruction.
+func (b *Block) Terminated() bool {
+	if len(b.Values) == 0 {
+		return false
 	}
-	if len(b.Preds) == 1 {
-		return b.Preds[0].ResolveVar(symbol)
-	} else if len(b.Preds) > 1 {
-		log.Printf("Assert: block %#v has multiple predecessors but no PHI for var %#v", b, symbol)
+	switch b.Values[len(b.Values)-1].(type) {
+	case *BranchOp, *BranchIfOp, *SwitchOp, *ReturnOp, *UnreachableOp:
+		return true
+	}
+	return false
+}
+
+// Edges returns the number of edges from the block to target, which
+// is more than one for switches with several cases jumping to target,
+// or conditional branches with both targets the same. Phis have one
+// operand per edge.
+func (b *Block) Edges(target *Block) int {
+	var labels []*Block
+	if b.Terminated() {
+		switch op := b.Values[len(b.Values)-1].(type) {
+		case *BranchOp:
+			labels = op.Labels
+		case *BranchIfOp:
+			labels = op.Labels
+		case *SwitchOp:
+			labels = op.Labels
+		}
+	}
+	n := 0
+	for _, l := range labels {
+		if l == target {
+			n++
+		}
 	}
-	return nil, false
+	if n == 0 {
+		return 1
+	}
+	return n
 }
 
 func (b *Block) Assign(symbol Register, value Value) Value {
 	res := b.Add(value)
-	b.Vars[symbol] = value
+	b.WriteVar(symbol, value)
 	return res
 }
 
@@ -325,28 +613,49 @@ func (b *Block) BranchIf(value Value, ifTrue, ifFalse *Block) {
 	b.Add(&BranchIfOp{BranchOp{[]*Block{ifTrue, ifFalse}}, value})
 }
 
+// Switch jumps to the target of the case equal to value, or to def.
+// The cases are distinct constants of the type of value.
+func (b *Block) Switch(value Value, def *Block, cases []Value, targets []*Block) {
+	b.Add(value)
+	def.AddPred(b)
+	for _, t := range targets {
+		t.AddPred(b)
+	}
+	b.Add(&SwitchOp{BranchOp{append([]*Block{def}, targets...)}, value, cases})
+}
+
 func (b *Block) Return(value Value) {
-	if b.Function.Type.ReturnType != value.Type() {
+	if value == nil {
+		b.Add(&ReturnOp{Valuable{Typ: VoidType()}, nil})
+		return
+	}
+	if !SameType(b.Function.Type.ReturnType, value.Type()) {
 		log.Printf("RETURNING. Should return %#v but it returns %#v", b.Function.Type.ReturnType, value)
 	}
 	b.Add(value)
 	b.Add(&ReturnOp{Valuable{Typ: value.Type()}, value})
 }
 
+func (b *Block) Unreachable() {
+	// not deduplicated by Add: pointers to zero
+	// sized values are not necessarily distinct
+	b.Values = append(b.Values, &UnreachableOp{})
+}
+
 func (b *Block) Name() string {
 	return fmt.Sprintf("%%label%d", b.Res)
 }
 
 func (b *Block) Prepare(fun *Function) {
 	b.Labelable.Prepare(fun)
+	for _, p := range b.Phis {
+		if p.Replacement == nil {
+			p.Prepare(fun, b)
+		}
+	}
 	for _, v := range b.Values {
 		v.Prepare(fun, b)
 	}
-	// insert phi nodes
-	for _, p := range b.Phis {
-		b.Vars[p.(*PhiOp).Sym] = p
-	}
-	b.Values = append(b.Phis, b.Values...)
 }
 
 func (b *Block) PrettyPreds() string {
@@ -365,6 +674,11 @@ func (b *Block) Emit(fun *Function) {
 		fun.Indent = ""
 	}()
 
+	for _, p := range b.Phis {
+		if p.Replacement == nil {
+			p.Emit(fun)
+		}
+	}
 	for _, v := range b.Values {
 		v.Emit(fun)
 	}
@@ -0,0 +1,146 @@
+package lovm
+
+// SSA construction.
+//
+// Variables (Registers) are converted to SSA form while the code
+// is being built, following Braun et al. "Simple and Efficient
+// Construction of Static Single Assignment Form" (CC 2013).
+//
+// Each block records the current definition of every variable
+// assigned in it. Reading a variable not defined locally looks
+// it up in the predecessors:
+//
+//  - a block with a single predecessor simply forwards the lookup;
+//  - a block with multiple predecessors gets a phi whose operands are
+//    looked up recursively in each predecessor. The phi is recorded
+//    as the definition before looking up the operands, which
+//    terminates the recursion on cycles;
+//  - a block whose predecessors are not all known yet (it's not
+//    sealed, e.g. a loop header before the back edge is built) gets
+//    an incomplete phi which is filled in when the block is sealed.
+//
+// Phis which turn out to merge a single value (other than
+// themselves) are trivial; they are replaced by that value and
+// phis using them are rechecked in turn.
+//
+// Reading a variable which is not defined on some path yields undef.
+
+// WriteVar records value as the current definition of symbol in the block.
+func (b *Block) WriteVar(symbol Register, value Value) {
+	b.Vars[symbol] = Resolve(value)
+}
+
+// ReadVar returns the definition of symbol reaching the current
+// end of the block, inserting phis as needed.
+func (b *Block) ReadVar(typ Type, symbol Register) Value {
+	if v, ok := b.Vars[symbol]; ok {
+		return Resolve(v)
+	}
+	return b.readVarRecursive(typ, symbol)
+}
+
+func (b *Block) readVarRecursive(typ Type, symbol Register) Value {
+	var res Value
+	if !b.Sealed {
+		phi := b.NewPhi(typ, symbol)
+		b.Incomplete = append(b.Incomplete, phi)
+		res = phi
+	} else if len(b.Preds) == 0 {
+		res = Undef(typ)
+	} else if len(b.Preds) == 1 {
+		res = b.Preds[0].ReadVar(typ, symbol)
+	} else {
+		phi := b.NewPhi(typ, symbol)
+		b.WriteVar(symbol, phi)
+		res = b.AddPhiOperands(phi)
+	}
+	b.WriteVar(symbol, res)
+	return res
+}
+
+// NewPhi creates an empty phi node for symbol at the
+// beginning of the block.
+func (b *Block) NewPhi(typ Type, symbol Register) *PhiOp {
+	phi := &PhiOp{Valuable: Valuable{Typ: typ}, Sym: symbol}
+	b.Phis = append(b.Phis, phi)
+	return phi
+}
+
+// AddPhiOperands fills the phi with the definitions reaching
+// it from each predecessor and returns the phi or, if the phi
+// was trivial, the value which replaced it.
+func (b *Block) AddPhiOperands(phi *PhiOp) Value {
+	for _, p := range b.Preds {
+		v := p.ReadVar(phi.Typ, phi.Sym)
+		for i := 0; i < p.Edges(b); i++ {
+			phi.Phis = append(phi.Phis, PhiParam{v, p})
+		}
+		if op, ok := v.(*PhiOp); ok {
+			op.Users = append(op.Users, phi)
+		}
+	}
+	return phi.TryRemoveTrivial()
+}
+
+// TryRemoveTrivial replaces the phi with its only operand
+// if it merges a single value, and rechecks the phis using it.
+func (phi *PhiOp) TryRemoveTrivial() Value {
+	var same Value
+	for _, param := range phi.Phis {
+		op := Resolve(param.Value)
+		if op == same || op == Value(phi) {
+			continue
+		}
+		if same != nil {
+			// merges at least two values: not trivial
+			return phi
+		}
+		same = op
+	}
+	if same == nil {
+		// unreachable or in the entry block
+		same = Undef(phi.Typ)
+	}
+	phi.Replacement = same
+	if p, ok := same.(*PhiOp); ok {
+		p.Users = append(p.Users, phi.Users...)
+	}
+
+	for _, user := range phi.Users {
+		if user != phi && user.Replacement == nil {
+			user.TryRemoveTrivial()
+		}
+	}
+	return same
+}
+
+// Seal declares that all the predecessors of the block are known
+// and completes the phis created while the block was unsealed.
+func (b *Block) Seal() {
+	if b.Sealed {
+		return
+	}
+	b.Sealed = true
+	for _, phi := range b.Incomplete {
+		b.AddPhiOperands(phi)
+	}
+	b.Incomplete = nil
+}
+
+// Resolve returns the value a variable read or a removed
+// phi stands for.
+func Resolve(v Value) Value {
+	for {
+		switch r := v.(type) {
+		case *RefOp:
+			v = r.Value
+		case *PhiOp:
+			if r.Replacement == nil {
+				return r
+			}
+			v = r.Replacement
+		default:
+			return v
+		}
+	}
+}
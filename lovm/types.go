@@ -3,6 +3,7 @@ package lovm
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -49,15 +50,15 @@ type FuncType struct {
 	Variadic   bool
 }
 
-func (f FuncType) Name() string {
+func (f *FuncType) Name() string {
 	return f.funcDecl("")
 }
 
-func (b FuncType) Dereference() Type {
+func (b *FuncType) Dereference() Type {
 	panic("dereferencing a non reference type")
 }
 
-func (f FuncType) funcDecl(name string) string {
+func (f *FuncType) funcDecl(name string) string {
 	args := make([]string, len(f.ParamTypes))
 	for i, p := range f.ParamTypes {
 		args[i] = p.Name()
@@ -73,18 +74,18 @@ func (f FuncType) funcDecl(name string) string {
 	return fmt.Sprintf("%s %s(%s)", f.ReturnType.Name(), sym, strings.Join(args, ", "))
 }
 
-func (f FuncType) EmitDecl(w io.Writer, name string) {
+func (f *FuncType) EmitDecl(w io.Writer, name string) {
 	fmt.Fprintf(w, "declare %s\n", f.funcDecl(name))
 }
 
-func (f FuncType) EmitDef(w io.Writer, name string, body func()) {
+func (f *FuncType) EmitDef(w io.Writer, name string, body func()) {
 	fmt.Fprintf(w, "define %s {\n", f.funcDecl(name))
 	body()
 	fmt.Fprintf(w, "}\n")
 }
 
-func FunctionType(ret Type, variadic bool, params ...Type) FuncType {
-	return FuncType{
+func FunctionType(ret Type, variadic bool, params ...Type) *FuncType {
+	return &FuncType{
 		ReturnType: ret,
 		ParamTypes: params,
 		Variadic:   variadic,
@@ -99,14 +100,126 @@ func ArrayType(typ Type, size int) Type {
 	return BasicType{fmt.Sprintf("[%d x %s]", size, typ.Name()), PointerType(typ)}
 }
 
+type StructureType struct {
+	Fields []Type
+	Packed bool
+}
+
+func (s *StructureType) Name() string {
+	fields := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		fields[i] = f.Name()
+	}
+	if s.Packed {
+		return fmt.Sprintf("<{ %s }>", strings.Join(fields, ", "))
+	}
+	return fmt.Sprintf("{ %s }", strings.Join(fields, ", "))
+}
+
+func (s *StructureType) Dereference() Type {
+	panic(fmt.Errorf("dereferencing a non reference type: %v", s.Name()))
+}
+
+func (s *StructureType) EmitDecl(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s = external global %s\n", name, s.Name())
+}
+
+func (s *StructureType) EmitDef(w io.Writer, name string, body func()) {
+	fmt.Fprintf(w, "%s = global %s ", name, s.Name())
+	body()
+	fmt.Fprintf(w, "\n")
+}
+
+func StructType(fields []Type, packed bool) Type {
+	return &StructureType{fields, packed}
+}
+
+// A NamedType is an identified structure type. Its definition is
+// emitted at the top of the module and it's referred to by name,
+// which allows recursive types. Until its body is set it's opaque.
+type NamedType struct {
+	name string
+	Body *StructureType
+}
+
+func (n *NamedType) Name() string {
+	return n.name
+}
+
+func (n *NamedType) Dereference() Type {
+	panic(fmt.Errorf("dereferencing a non reference type: %v", n.Name()))
+}
+
+func (n *NamedType) EmitDecl(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s = external global %s\n", name, n.Name())
+}
+
+func (n *NamedType) EmitDef(w io.Writer, name string, body func()) {
+	fmt.Fprintf(w, "%s = global %s ", name, n.Name())
+	body()
+	fmt.Fprintf(w, "\n")
+}
+
+// SetBody defines the fields of a named type.
+func (n *NamedType) SetBody(fields []Type, packed bool) {
+	n.Body = &StructureType{fields, packed}
+}
+
+// Opaque returns true if the body of the type is not known.
+func (n *NamedType) Opaque() bool {
+	return n.Body == nil
+}
+
+// EmitTypeDef emits the definition of the type.
+func (n *NamedType) EmitTypeDef(w io.Writer) {
+	if n.Opaque() {
+		fmt.Fprintf(w, "%s = type opaque\n", n.Name())
+		return
+	}
+	fmt.Fprintf(w, "%s = type %s\n", n.Name(), n.Body.Name())
+}
+
 func VoidType() Type {
 	return BasicType{"void", nil}
 }
 
-func DereferenceTypes(base Type, indices ...int) Type {
-	if len(indices) > 0 {
-		return DereferenceTypes(base.Dereference(), indices[1:]...)
-	} else {
+// SameType returns true if a and b denote the same llvm type.
+func SameType(a, b Type) bool {
+	return a.Name() == b.Name()
+}
+
+// ElementType returns the type of the element of an aggregate
+// addressed by the indices of an extractvalue or insertvalue.
+func ElementType(agg Type, indices ...int) Type {
+	if len(indices) == 0 {
+		return agg
+	}
+	switch t := agg.(type) {
+	case *StructureType:
+		return ElementType(t.Fields[indices[0]], indices[1:]...)
+	case *NamedType:
+		if t.Opaque() {
+			panic(fmt.Errorf("indexing opaque type %s", t.Name()))
+		}
+		return ElementType(t.Body, indices...)
+	default:
+		// arrays
+		return ElementType(t.Dereference().Dereference(), indices[1:]...)
+	}
+}
+
+// DereferenceTypes returns the type of the pointer computed
+// by a getelementptr on base with the given indices.
+func DereferenceTypes(base Type, indices ...Value) Type {
+	if len(indices) == 0 {
 		return base
 	}
+	// only the indices into structures matter
+	consts := make([]int, len(indices)-1)
+	for i, idx := range indices[1:] {
+		if c, ok := idx.(Const); ok {
+			consts[i], _ = strconv.Atoi(c.Val)
+		}
+	}
+	return PointerType(ElementType(base.Dereference(), consts...))
 }
@@ -0,0 +1,360 @@
+// Channels.
+//
+// A channel has a ring buffer of cap elements and queues of the
+// goroutines blocked sending to it or receiving from it. A blocked
+// goroutine is handed its value, or takes the one it sends, by the
+// goroutine completing the operation, which readies it. All the
+// channels are guarded by the runtime lock, see proc.c.
+//
+// A select statement blocked on several channels queues a waiter on
+// each of them, all sharing its parking: the first operation to ready
+// it wins, and the others skip the waiters already readied.
+
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+#include "runtime.h"
+
+typedef struct waiter {
+	goal_parking *p;
+	// case of the select statement, if any
+	int64_t index;
+	// the element sent, or receiving the value, which may be NULL
+	void *elem;
+	// set when the operation completes, rather than because
+	// the channel was closed
+	int64_t ok;
+	struct waiter *next;
+} waiter;
+
+typedef struct {
+	waiter *first;
+	waiter *last;
+} waitq;
+
+struct goal_chan {
+	int64_t elemsize;
+	int64_t cap;
+	// number and index of the first of the buffered elements
+	int64_t len;
+	int64_t head;
+	char *buf;
+	int64_t closed;
+	waitq recvq;
+	waitq sendq;
+};
+
+static void enqueue(waitq *q, waiter *w) {
+	w->next = NULL;
+	if (q->last == NULL) {
+		q->first = w;
+	} else {
+		q->last->next = w;
+	}
+	q->last = w;
+}
+
+// dequeue removes and returns the first waiter of q which
+// hasn't been readied yet, or NULL.
+static waiter *dequeue(waitq *q) {
+	while (q->first != NULL) {
+		waiter *w = q->first;
+		q->first = w->next;
+		if (q->first == NULL) {
+			q->last = NULL;
+		}
+		if (!w->p->done) {
+			return w;
+		}
+	}
+	return NULL;
+}
+
+// removewaiter removes w from q, if queued.
+static void removewaiter(waitq *q, waiter *w) {
+	waiter *prev = NULL;
+	for (waiter *x = q->first; x != NULL; prev = x, x = x->next) {
+		if (x != w) {
+			continue;
+		}
+		if (prev == NULL) {
+			q->first = w->next;
+		} else {
+			prev->next = w->next;
+		}
+		if (q->last == w) {
+			q->last = prev;
+		}
+		return;
+	}
+}
+
+static void copyelem(goal_chan *c, void *dst, const void *src) {
+	if (dst != NULL) {
+		memcpy(dst, src, c->elemsize);
+	}
+}
+
+static void *slot(goal_chan *c, int64_t i) {
+	return c->buf + (c->head + i) % c->cap * c->elemsize;
+}
+
+// trysend sends the element, if it can be done without blocking,
+// to a blocked receiver or to the buffer, and returns 1 if sent.
+static int trysend(goal_chan *c, const void *elem) {
+	waiter *w = dequeue(&c->recvq);
+	if (w != NULL) {
+		copyelem(c, w->elem, elem);
+		w->ok = 1;
+		goal_ready(w->p, w->index);
+		return 1;
+	}
+	if (c->len < c->cap) {
+		memcpy(slot(c, c->len), elem, c->elemsize);
+		c->len++;
+		return 1;
+	}
+	return 0;
+}
+
+// tryrecv receives an element into elem, if it can be done without
+// blocking, from the buffer, a blocked sender or a closed channel,
+// and returns 1 if received, setting *ok.
+static int tryrecv(goal_chan *c, void *elem, int64_t *ok) {
+	if (c->len > 0) {
+		copyelem(c, elem, slot(c, 0));
+		c->head = (c->head + 1) % c->cap;
+		c->len--;
+		// room for the element of a blocked sender
+		waiter *w = dequeue(&c->sendq);
+		if (w != NULL) {
+			memcpy(slot(c, c->len), w->elem, c->elemsize);
+			c->len++;
+			w->ok = 1;
+			goal_ready(w->p, w->index);
+		}
+		*ok = 1;
+		return 1;
+	}
+	waiter *w = dequeue(&c->sendq);
+	if (w != NULL) {
+		copyelem(c, elem, w->elem);
+		w->ok = 1;
+		goal_ready(w->p, w->index);
+		*ok = 1;
+		return 1;
+	}
+	if (c->closed) {
+		if (elem != NULL) {
+			memset(elem, 0, c->elemsize);
+		}
+		*ok = 0;
+		return 1;
+	}
+	return 0;
+}
+
+// block parks the running goroutine forever, as
+// operations on nil channels do, unless deadlocked.
+static void __attribute__((noreturn)) block(void) {
+	goal_parking p = {NULL, 0, -1};
+	goal_lock();
+	goal_park(&p);
+	abort();
+}
+
+// goal_makechan allocates a channel of elements of
+// elemsize bytes with a buffer of size elements.
+goal_chan *goal_makechan(int64_t elemsize, int64_t size) {
+	if (size < 0 || (elemsize > 0 && size > INT64_MAX / elemsize)) {
+		goal_panic_error(NULL, "makechan: size out of range");
+	}
+	goal_chan *c = goal_alloc(1, sizeof(goal_chan));
+	c->elemsize = elemsize;
+	c->cap = size;
+	c->buf = goal_alloc(size, elemsize);
+	return c;
+}
+
+// goal_chansend sends the element at elem, blocking until
+// it is received or buffered.
+void goal_chansend(goal_chan *c, void *elem) {
+	if (c == NULL) {
+		block();
+	}
+	goal_lock();
+	if (c->closed) {
+		goal_unlock();
+		goal_panic_error(NULL, "send on closed channel");
+	}
+	if (trysend(c, elem)) {
+		goal_unlock();
+		return;
+	}
+	goal_parking p = {NULL, 0, -1};
+	waiter w = {&p, 0, elem, 0, NULL};
+	enqueue(&c->sendq, &w);
+	goal_park(&p);
+	goal_unlock();
+	if (!w.ok) {
+		goal_panic_error(NULL, "send on closed channel");
+	}
+}
+
+// goal_chanrecv receives an element into elem, blocking until one is
+// sent, and returns 1, or 0 with the zero value if c is closed.
+int64_t goal_chanrecv(goal_chan *c, void *elem) {
+	if (c == NULL) {
+		block();
+	}
+	int64_t ok;
+	goal_lock();
+	if (tryrecv(c, elem, &ok)) {
+		goal_unlock();
+		return ok;
+	}
+	goal_parking p = {NULL, 0, -1};
+	waiter w = {&p, 0, elem, 0, NULL};
+	enqueue(&c->recvq, &w);
+	goal_park(&p);
+	goal_unlock();
+	return w.ok;
+}
+
+// goal_closechan closes c, readying the goroutines blocked on it:
+// receivers get the zero value and senders panic.
+void goal_closechan(goal_chan *c) {
+	if (c == NULL) {
+		goal_panic_error(NULL, "close of nil channel");
+	}
+	goal_lock();
+	if (c->closed) {
+		goal_unlock();
+		goal_panic_error(NULL, "close of closed channel");
+	}
+	c->closed = 1;
+	waiter *w;
+	while ((w = dequeue(&c->recvq)) != NULL) {
+		if (w->elem != NULL) {
+			memset(w->elem, 0, c->elemsize);
+		}
+		goal_ready(w->p, w->index);
+	}
+	while ((w = dequeue(&c->sendq)) != NULL) {
+		goal_ready(w->p, w->index);
+	}
+	goal_unlock();
+}
+
+// goal_chanlen returns the number of buffered elements of c.
+int64_t goal_chanlen(goal_chan *c) {
+	if (c == NULL) {
+		return 0;
+	}
+	goal_lock();
+	int64_t len = c->len;
+	goal_unlock();
+	return len;
+}
+
+// goal_chancap returns the size of the buffer of c.
+int64_t goal_chancap(goal_chan *c) {
+	return c == NULL ? 0 : c->cap;
+}
+
+// directions of the cases of a select statement, as in go/ast
+#define SEND 1
+#define RECV 2
+
+// scase matches the { i8*, i8*, i64 } cases of select statements,
+// holding the channel, the element sent or receiving the value,
+// and the direction.
+typedef struct {
+	goal_chan *c;
+	void *elem;
+	int64_t dir;
+} scase;
+
+// fastrand returns a pseudo-random number, from a
+// xorshift generator private to the thread.
+static uint64_t fastrand(void) {
+	static _Thread_local uint64_t state;
+	if (state == 0) {
+		state = ((uint64_t)time(NULL) ^ (uint64_t)goal_getg()->id * 0x9e3779b97f4a7c15) | 1;
+	}
+	state ^= state << 13;
+	state ^= state >> 7;
+	state ^= state << 17;
+	return state;
+}
+
+static waitq *queue(scase *sc) {
+	return sc->dir == SEND ? &sc->c->sendq : &sc->c->recvq;
+}
+
+// goal_select runs the select statement with the n cases, returning
+// the index of the case picked, among the ready ones at random, and
+// setting *recvok as goal_chanrecv does if it is a receive. Unless
+// blocking is set, it returns -1 if no case is ready, for the
+// default clause, and otherwise blocks until one is. Nil channels
+// are never ready.
+int64_t goal_select(scase *cases, int64_t n, int64_t blocking, int64_t *recvok) {
+	// polled in a random order
+	int64_t order[n > 0 ? n : 1];
+	for (int64_t i = 0; i < n; i++) {
+		int64_t j = fastrand() % (i + 1);
+		order[i] = order[j];
+		order[j] = i;
+	}
+
+	goal_lock();
+	for (int64_t k = 0; k < n; k++) {
+		int64_t i = order[k];
+		scase *sc = &cases[i];
+		if (sc->c == NULL) {
+			continue;
+		}
+		if (sc->dir == SEND) {
+			if (sc->c->closed) {
+				goal_unlock();
+				goal_panic_error(NULL, "send on closed channel");
+			}
+			if (trysend(sc->c, sc->elem)) {
+				goal_unlock();
+				return i;
+			}
+		} else if (tryrecv(sc->c, sc->elem, recvok)) {
+			goal_unlock();
+			return i;
+		}
+	}
+	if (!blocking) {
+		goal_unlock();
+		return -1;
+	}
+
+	// with no channels, as in select {}, blocks forever
+	goal_parking p = {NULL, 0, -1};
+	waiter waiters[n > 0 ? n : 1];
+	for (int64_t i = 0; i < n; i++) {
+		if (cases[i].c != NULL) {
+			waiters[i] = (waiter){&p, i, cases[i].elem, 0, NULL};
+			enqueue(queue(&cases[i]), &waiters[i]);
+		}
+	}
+	goal_park(&p);
+	for (int64_t i = 0; i < n; i++) {
+		if (cases[i].c != NULL) {
+			removewaiter(queue(&cases[i]), &waiters[i]);
+		}
+	}
+	goal_unlock();
+
+	int64_t i = p.index;
+	if (cases[i].dir == SEND && !waiters[i].ok) {
+		goal_panic_error(NULL, "send on closed channel");
+	}
+	*recvok = waiters[i].ok;
+	return i;
+}
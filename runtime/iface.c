@@ -0,0 +1,134 @@
+// Interface values.
+//
+// Interface values are pairs of an itab, NULL for nil interfaces,
+// and a data word. The itabs of conversions from concrete types are
+// generated by glc, the others are built here from the method table
+// of the dynamic type on first use.
+
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "runtime.h"
+
+typedef struct cached_itab {
+	const goal_interfacetype *iface;
+	const goal_itab *itab;
+	struct cached_itab *next;
+} cached_itab;
+
+static cached_itab *itabs;
+
+static void panic_conversion(const char *format, ...) {
+	char msg[256];
+	va_list args;
+	va_start(args, format);
+	vsnprintf(msg, sizeof(msg), format, args);
+	va_end(args);
+	goal_panic_error(NULL, "interface conversion: %s", msg);
+}
+
+// namelen returns the length of the name of a method key.
+static int namelen(const char *key) {
+	return (int)strcspn(key, "(");
+}
+
+// goal_itabtype returns the dynamic type of the interface
+// values with the given itab, NULL for nil interfaces.
+const goal_type *goal_itabtype(const goal_itab *tab) {
+	return tab == NULL ? NULL : tab->type;
+}
+
+// goal_getitab returns the itab of the dynamic type t for the interface
+// type it. If t is NULL or doesn't implement it, goal_getitab returns
+// NULL if canfail is set and panics otherwise.
+const goal_itab *goal_getitab(const goal_interfacetype *it, const goal_type *t, int64_t canfail) {
+	if (t == NULL) {
+		if (canfail) {
+			return NULL;
+		}
+		panic_conversion("interface is nil, not %s", it->name);
+	}
+	goal_lock();
+	for (cached_itab *c = itabs; c != NULL; c = c->next) {
+		if (c->iface == it && c->itab->type == t) {
+			goal_unlock();
+			return c->itab;
+		}
+	}
+	goal_unlock();
+
+	goal_itab *tab = goal_alloc(1, sizeof(goal_itab) + it->nmethods * sizeof(void *));
+	tab->type = t;
+	int64_t j = 0;
+	for (int64_t i = 0; i < it->nmethods; i++) {
+		const char *key = it->methods[i];
+		while (j < t->nmethods && strcmp(t->methods[j].name, key) < 0) {
+			j++;
+		}
+		if (j == t->nmethods || strcmp(t->methods[j].name, key) != 0) {
+			free(tab);
+			if (canfail) {
+				return NULL;
+			}
+			panic_conversion("%s is not %s: missing method %.*s", t->name, it->name, namelen(key), key);
+		}
+		tab->fun[i] = t->methods[j].fn;
+	}
+
+	cached_itab *c = goal_alloc(1, sizeof(cached_itab));
+	c->iface = it;
+	c->itab = tab;
+	// goroutines racing to build the same itab insert it twice
+	goal_lock();
+	c->next = itabs;
+	itabs = c;
+	goal_unlock();
+	return tab;
+}
+
+// goal_panicassert reports a failed assertion that an interface
+// value of type iface has dynamic type want, while it has have.
+void goal_panicassert(const goal_type *have, const goal_type *want, const char *iface) {
+	if (have == NULL) {
+		panic_conversion("%s is nil, not %s", iface, want->name);
+	}
+	panic_conversion("%s is %s, not %s", iface, have->name, want->name);
+}
+
+static void uncomparable(const char *format, const goal_type *t) {
+	char msg[256];
+	snprintf(msg, sizeof(msg), format, t->name);
+	goal_panic_message(msg);
+}
+
+// goal_ifacehash hashes the interface value x into h.
+uint64_t goal_ifacehash(uint64_t h, const goal_iface *x) {
+	static const int64_t word[] = {0, sizeof(void *)};
+	const goal_type *t = goal_itabtype(x->itab);
+	h = goal_hashfields(h, word, 1, (const char *)&t);
+	if (t == NULL || t->direct) {
+		return goal_hashfields(h, word, 1, (const char *)&x->data);
+	}
+	if (t->nfields < 0) {
+		uncomparable("hash of unhashable type %s", t);
+	}
+	return goal_hashfields(h, t->fields, t->nfields, x->data);
+}
+
+// goal_ifaceeq returns 1 if the interface values { xtab, x }
+// and { ytab, y } are equal, and 0 otherwise.
+int64_t goal_ifaceeq(const goal_itab *xtab, void *x, const goal_itab *ytab, void *y) {
+	const goal_type *t = goal_itabtype(xtab);
+	if (t != goal_itabtype(ytab)) {
+		return 0;
+	}
+	if (t == NULL || t->direct) {
+		return x == y;
+	}
+	if (t->nfields < 0) {
+		uncomparable("comparing uncomparable type %s", t);
+	}
+	return goal_equalfields(t->fields, t->nfields, x, y);
+}
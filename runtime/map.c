@@ -0,0 +1,315 @@
+// Hash tables backing maps.
+//
+// Entries are kept in a dense array, which iterators walk in
+// order, and are located through an open addressing index of
+// entry numbers. The entries of deleted keys are reused.
+//
+// Keys are hashed and compared as described by a list of fields,
+// pairs of offset and size in the key, where a size of 0 denotes
+// a string compared by contents and a size of -1 an interface value
+// compared by dynamic type and value. The other fields are compared
+// bytewise, which leaves out the padding of struct keys.
+
+#include <stdlib.h>
+#include <string.h>
+
+#include "runtime.h"
+
+#define EMPTY 0
+#define DELETED -1
+
+// size of interface fields
+#define IFACE -1
+
+typedef struct {
+	uint64_t hash;
+	int64_t used;
+	// followed by the key and the value
+} entry;
+
+struct goal_map {
+	int64_t keysize;
+	int64_t valsize;
+	int64_t entrysize;
+	const int64_t *fields;
+	int64_t nfields;
+	int64_t len;
+
+	char *entries;
+	int64_t nentries;
+	int64_t capentries;
+
+	// entries of deleted keys
+	int64_t *free;
+	int64_t nfree;
+	int64_t capfree;
+
+	// slots hold EMPTY, DELETED or an entry number plus 1
+	int64_t *index;
+	int64_t indexsize;
+	// slots not EMPTY
+	int64_t indexused;
+};
+
+struct goal_mapiter {
+	goal_map *m;
+	int64_t next;
+};
+
+static int64_t align8(int64_t n) {
+	return (n + 7) & ~7;
+}
+
+static entry *entry_at(goal_map *m, int64_t i) {
+	return (entry *)(m->entries + i * m->entrysize);
+}
+
+static char *entry_key(entry *e) {
+	return (char *)(e + 1);
+}
+
+static char *entry_val(goal_map *m, entry *e) {
+	return entry_key(e) + align8(m->keysize);
+}
+
+static uint64_t fnv(uint64_t h, const char *p, int64_t n) {
+	for (int64_t i = 0; i < n; i++) {
+		h ^= (uint8_t)p[i];
+		h *= 1099511628211ULL;
+	}
+	return h;
+}
+
+// goal_hashfields hashes the fields of the value at p into h.
+uint64_t goal_hashfields(uint64_t h, const int64_t *fields, int64_t nfields, const char *p) {
+	for (int64_t i = 0; i < nfields; i++) {
+		const char *f = p + fields[2 * i];
+		int64_t size = fields[2 * i + 1];
+		if (size == 0) {
+			const goal_string *s = (const goal_string *)f;
+			h = fnv(h, s->ptr, s->len);
+		} else if (size == IFACE) {
+			h = goal_ifacehash(h, (const goal_iface *)f);
+		} else {
+			h = fnv(h, f, size);
+		}
+	}
+	return h;
+}
+
+// goal_equalfields compares the fields of the values at a and b.
+int goal_equalfields(const int64_t *fields, int64_t nfields, const char *a, const char *b) {
+	for (int64_t i = 0; i < nfields; i++) {
+		int64_t off = fields[2 * i];
+		int64_t size = fields[2 * i + 1];
+		if (size == 0) {
+			const goal_string *s = (const goal_string *)(a + off);
+			const goal_string *t = (const goal_string *)(b + off);
+			if (s->len != t->len || (s->len > 0 && memcmp(s->ptr, t->ptr, s->len) != 0)) {
+				return 0;
+			}
+		} else if (size == IFACE) {
+			const goal_iface *x = (const goal_iface *)(a + off);
+			const goal_iface *y = (const goal_iface *)(b + off);
+			if (!goal_ifaceeq(x->itab, x->data, y->itab, y->data)) {
+				return 0;
+			}
+		} else if (memcmp(a + off, b + off, size) != 0) {
+			return 0;
+		}
+	}
+	return 1;
+}
+
+static uint64_t hashkey(goal_map *m, const char *key) {
+	return goal_hashfields(14695981039346656037ULL, m->fields, m->nfields, key);
+}
+
+static int keyequal(goal_map *m, const char *a, const char *b) {
+	return goal_equalfields(m->fields, m->nfields, a, b);
+}
+
+// find returns the index slot of the entry of key,
+// or NULL if the key is not in the map.
+static int64_t *find(goal_map *m, const char *key, uint64_t hash) {
+	int64_t mask = m->indexsize - 1;
+	for (int64_t i = hash & mask;; i = (i + 1) & mask) {
+		int64_t slot = m->index[i];
+		if (slot == EMPTY) {
+			return NULL;
+		}
+		if (slot != DELETED) {
+			entry *e = entry_at(m, slot - 1);
+			if (e->hash == hash && keyequal(m, entry_key(e), key)) {
+				return &m->index[i];
+			}
+		}
+	}
+}
+
+// insert adds entry n to the index, which must not contain it.
+static void insert(goal_map *m, int64_t n, uint64_t hash) {
+	int64_t mask = m->indexsize - 1;
+	int64_t i = hash & mask;
+	while (m->index[i] != EMPTY && m->index[i] != DELETED) {
+		i = (i + 1) & mask;
+	}
+	if (m->index[i] == EMPTY) {
+		m->indexused++;
+	}
+	m->index[i] = n + 1;
+}
+
+// rehash rebuilds the index, dropping the deleted slots and
+// growing it so that at most half of the slots are used.
+static void rehash(goal_map *m) {
+	int64_t size = m->indexsize;
+	while ((m->len + 1) * 2 > size) {
+		size *= 2;
+	}
+	free(m->index);
+	m->index = goal_alloc(size, sizeof(int64_t));
+	m->indexsize = size;
+	m->indexused = 0;
+	for (int64_t i = 0; i < m->nentries; i++) {
+		entry *e = entry_at(m, i);
+		if (e->used) {
+			insert(m, i, e->hash);
+		}
+	}
+}
+
+// newentry returns the number of an unused entry.
+static int64_t newentry(goal_map *m) {
+	if (m->nfree > 0) {
+		return m->free[--m->nfree];
+	}
+	if (m->nentries == m->capentries) {
+		int64_t cap = m->capentries * 2;
+		char *entries = goal_alloc(cap, m->entrysize);
+		memcpy(entries, m->entries, m->nentries * m->entrysize);
+		free(m->entries);
+		m->entries = entries;
+		m->capentries = cap;
+	}
+	return m->nentries++;
+}
+
+// goal_makemap allocates a map whose keys are laid out as described by
+// the nfields pairs of offset and size in fields, with room for hint entries.
+goal_map *goal_makemap(int64_t keysize, int64_t valsize, const int64_t *fields, int64_t nfields, int64_t hint) {
+	if (hint < 0 || hint > GOAL_INT_MAX) {
+		goal_panic_message("makemap: size out of range");
+	}
+	goal_map *m = goal_alloc(1, sizeof(goal_map));
+	m->keysize = keysize;
+	m->valsize = valsize;
+	m->entrysize = sizeof(entry) + align8(keysize) + align8(valsize);
+	m->fields = fields;
+	m->nfields = nfields;
+
+	m->capentries = hint < 8 ? 8 : hint;
+	m->entries = goal_alloc(m->capentries, m->entrysize);
+	m->indexsize = 8;
+	while (m->indexsize < 2 * m->capentries) {
+		m->indexsize *= 2;
+	}
+	m->index = goal_alloc(m->indexsize, sizeof(int64_t));
+	return m;
+}
+
+// goal_mapaccess copies the value of key to val and returns 1 if
+// the key is in the map, otherwise it returns 0 leaving val unchanged.
+int64_t goal_mapaccess(goal_map *m, const void *key, void *val) {
+	if (m == NULL || m->len == 0) {
+		return 0;
+	}
+	int64_t *slot = find(m, key, hashkey(m, key));
+	if (slot == NULL) {
+		return 0;
+	}
+	memcpy(val, entry_val(m, entry_at(m, *slot - 1)), m->valsize);
+	return 1;
+}
+
+// goal_mapassign returns the address of the value of key, adding
+// the key with a zero value if it's not in the map. The address
+// is valid until the next change to the map.
+void *goal_mapassign(goal_map *m, const void *key) {
+	if (m == NULL) {
+		goal_panic_message("assignment to entry in nil map");
+	}
+	uint64_t hash = hashkey(m, key);
+	int64_t *slot = find(m, key, hash);
+	if (slot != NULL) {
+		return entry_val(m, entry_at(m, *slot - 1));
+	}
+
+	if ((m->indexused + 1) * 4 > m->indexsize * 3) {
+		rehash(m);
+	}
+	int64_t n = newentry(m);
+	entry *e = entry_at(m, n);
+	e->hash = hash;
+	e->used = 1;
+	memcpy(entry_key(e), key, m->keysize);
+	insert(m, n, hash);
+	m->len++;
+	return entry_val(m, e);
+}
+
+// goal_mapdelete removes key from the map, if present.
+void goal_mapdelete(goal_map *m, const void *key) {
+	if (m == NULL || m->len == 0) {
+		return;
+	}
+	int64_t *slot = find(m, key, hashkey(m, key));
+	if (slot == NULL) {
+		return;
+	}
+	int64_t n = *slot - 1;
+	*slot = DELETED;
+	memset(entry_at(m, n), 0, m->entrysize);
+	m->len--;
+
+	if (m->nfree == m->capfree) {
+		int64_t cap = m->capfree == 0 ? 8 : 2 * m->capfree;
+		int64_t *f = goal_alloc(cap, sizeof(int64_t));
+		memcpy(f, m->free, m->nfree * sizeof(int64_t));
+		free(m->free);
+		m->free = f;
+		m->capfree = cap;
+	}
+	m->free[m->nfree++] = n;
+}
+
+// goal_maplen returns the number of keys in the map.
+int64_t goal_maplen(goal_map *m) {
+	return m == NULL ? 0 : m->len;
+}
+
+// goal_mapiterinit starts an iteration over the map. Keys added
+// during the iteration may or may not be visited.
+goal_mapiter *goal_mapiterinit(goal_map *m) {
+	goal_mapiter *it = goal_alloc(1, sizeof(goal_mapiter));
+	it->m = m;
+	return it;
+}
+
+// goal_mapiternext copies the next key and value of the iteration
+// to key and val and returns 1, or returns 0 at the end.
+int64_t goal_mapiternext(goal_mapiter *it, void *key, void *val) {
+	goal_map *m = it->m;
+	if (m == NULL) {
+		return 0;
+	}
+	while (it->next < m->nentries) {
+		entry *e = entry_at(m, it->next++);
+		if (e->used) {
+			memcpy(key, entry_key(e), m->keysize);
+			memcpy(val, entry_val(m, e), m->valsize);
+			return 1;
+		}
+	}
+	return 0;
+}
@@ -0,0 +1,264 @@
+// Deferred calls, panics and recovery.
+//
+// Functions with deferred calls push a frame when entered, and set
+// its jump buffer as the landing point of recovered panics, which
+// returns from the function. The frame holds the deferred calls,
+// which are run by goal_popframe when the function returns, or by
+// goal_panic while unwinding the stack. Each deferred call is a
+// function taking an environment holding the function value and
+// the arguments evaluated by the defer statement.
+
+#include <execinfo.h>
+#include <setjmp.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "runtime.h"
+
+typedef struct deferred {
+	void (*fn)(void *env);
+	void *env;
+	struct deferred *next;
+} deferred;
+
+struct goal_frame {
+	// first, since compiled code passes the frame to setjmp
+	jmp_buf jmp;
+	// the last deferred call first
+	deferred *defers;
+	goal_frame *parent;
+};
+
+struct goal_panicking {
+	goal_iface value;
+	int recovered;
+	// frame whose deferred calls are being run
+	goal_frame *frame;
+	// panic interrupted by this one, if any
+	goal_panicking *link;
+};
+
+// runtime_error is the dynamic type of the panics
+// raised by the runtime, which implement error.
+typedef struct {
+	goal_string msg;
+	// source position, if known
+	const char *pos;
+} runtime_error;
+
+static goal_string runtime_error_Error(void *data) {
+	return ((runtime_error *)data)->msg;
+}
+
+static const goal_method runtime_error_methods[] = {
+	{"Error() string", runtime_error_Error},
+};
+
+static const goal_type runtime_error_type = {
+	"*runtime.Error", sizeof(void *), 1, NULL, 0, runtime_error_methods, 1,
+};
+
+// held in interface {} values
+static const goal_itab runtime_error_itab = {&runtime_error_type};
+
+// goal_panic_error panics with a runtime error whose
+// message is formatted, raised at the source position pos.
+void goal_panic_error(const char *pos, const char *format, ...) {
+	va_list args;
+	va_start(args, format);
+	int len = vsnprintf(NULL, 0, format, args);
+	va_end(args);
+	char *msg = goal_alloc(len + 1, 1);
+	va_start(args, format);
+	vsnprintf(msg, len + 1, format, args);
+	va_end(args);
+
+	runtime_error *e = goal_alloc(1, sizeof(runtime_error));
+	e->msg.ptr = msg;
+	e->msg.len = len;
+	e->pos = pos;
+	goal_panic(&runtime_error_itab, e);
+}
+
+// findmethod returns the method of the dynamic type t
+// with the given key, or NULL.
+static void *findmethod(const goal_type *t, const char *key) {
+	for (int64_t i = 0; i < t->nmethods; i++) {
+		if (strcmp(t->methods[i].name, key) == 0) {
+			return t->methods[i].fn;
+		}
+	}
+	return NULL;
+}
+
+// printvalue prints the value of a panic as the Go runtime
+// does, using its Error or String method if it has one.
+static void printvalue(goal_iface v) {
+	const goal_type *t = goal_itabtype(v.itab);
+	if (t == NULL) {
+		fprintf(stderr, "nil");
+		return;
+	}
+	goal_string (*method)(void *) = findmethod(t, "Error() string");
+	if (method == NULL) {
+		method = findmethod(t, "String() string");
+	}
+	if (method != NULL) {
+		goal_string s = method(v.data);
+		fprintf(stderr, "%.*s", (int)s.len, s.ptr);
+		return;
+	}
+
+	const char *name = t->name;
+	if (strcmp(name, "string") == 0) {
+		goal_string *s = v.data;
+		fprintf(stderr, "%.*s", (int)s->len, s->ptr);
+	} else if (strcmp(name, "bool") == 0) {
+		fputs(*(int8_t *)v.data ? "true" : "false", stderr);
+	} else if (strcmp(name, "float64") == 0) {
+		fprintf(stderr, "%e", *(double *)v.data);
+	} else if (strcmp(name, "float32") == 0) {
+		fprintf(stderr, "%e", *(float *)v.data);
+	} else if (name[0] == 'u' && strncmp(name, "uint", 4) == 0) {
+		uint64_t x = 0;
+		memcpy(&x, v.data, t->size);
+		fprintf(stderr, "%llu", (unsigned long long)x);
+	} else if (strncmp(name, "int", 3) == 0 || strcmp(name, "byte") == 0 || strcmp(name, "rune") == 0) {
+		int64_t x = 0;
+		memcpy(&x, v.data, t->size);
+		// sign extend
+		int shift = 64 - 8 * t->size;
+		fprintf(stderr, "%lld", (long long)((x << shift) >> shift));
+	} else {
+		fprintf(stderr, "(%s) %p", name, v.data);
+	}
+}
+
+// printpanics prints the chain of panics, the oldest first.
+static void printpanics(const goal_panicking *p) {
+	if (p->link != NULL) {
+		printpanics(p->link);
+		fprintf(stderr, "\t");
+	}
+	fprintf(stderr, "panic: ");
+	printvalue(p->value);
+	if (p->recovered) {
+		fprintf(stderr, " [recovered]");
+	}
+	fprintf(stderr, "\n");
+}
+
+// fatalpanic reports an uncaught panic with a trace
+// of the goroutine, and exits the program.
+static void __attribute__((noreturn)) fatalpanic(goal_g *gp, goal_panicking *p) {
+	void *trace[64];
+	printpanics(p);
+	fprintf(stderr, "\ngoroutine %lld [running]:\n", (long long)gp->id);
+	if (goal_itabtype(p->value.itab) == &runtime_error_type) {
+		runtime_error *e = p->value.data;
+		if (e->pos != NULL) {
+			fprintf(stderr, "at %s\n", e->pos);
+		}
+	}
+	backtrace_symbols_fd(trace, backtrace(trace, 64), 2);
+	exit(2);
+}
+
+// goal_pushframe enters a function with deferred calls,
+// returning its frame.
+goal_frame *goal_pushframe(void) {
+	goal_g *gp = goal_getg();
+	goal_frame *f = goal_alloc(1, sizeof(goal_frame));
+	f->parent = gp->frames;
+	gp->frames = f;
+	return f;
+}
+
+// rundefer runs the last deferred call of the frame f.
+static void rundefer(goal_g *gp, goal_frame *f) {
+	deferred *d = f->defers;
+	f->defers = d->next;
+	goal_frame *saved = gp->deferframe;
+	gp->deferframe = f;
+	d->fn(d->env);
+	gp->deferframe = saved;
+	free(d);
+}
+
+// goal_popframe returns from the function with the frame f,
+// running its deferred calls.
+void goal_popframe(goal_frame *f) {
+	goal_g *gp = goal_getg();
+	while (f->defers != NULL) {
+		rundefer(gp, f);
+	}
+	gp->frames = f->parent;
+	free(f);
+}
+
+// goal_defer defers the call of fn with the environment
+// env until the function with the frame f returns.
+void goal_defer(goal_frame *f, void (*fn)(void *), void *env) {
+	deferred *d = goal_alloc(1, sizeof(deferred));
+	d->fn = fn;
+	d->env = env;
+	d->next = f->defers;
+	f->defers = d;
+}
+
+// above returns true if the frame f was pushed after the frame g.
+static int above(const goal_frame *f, const goal_frame *g) {
+	for (f = f->parent; f != NULL; f = f->parent) {
+		if (f == g) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// goal_panic panics with the value { itab, data }, running the
+// deferred calls of the goroutine until one of them recovers.
+void goal_panic(const goal_itab *itab, void *data) {
+	if (itab == NULL) {
+		goal_panic_error(NULL, "panic called with nil argument");
+	}
+	goal_g *gp = goal_getg();
+	goal_panicking p = {{itab, data}, 0, NULL, gp->panic};
+	gp->panic = &p;
+	while (gp->frames != NULL) {
+		goal_frame *f = gp->frames;
+		p.frame = f;
+		while (f->defers != NULL) {
+			rundefer(gp, f);
+			if (p.recovered) {
+				// the panics interrupted while running the deferred
+				// calls of the frames unwound by this one are aborted
+				gp->panic = p.link;
+				while (gp->panic != NULL && !above(f, gp->panic->frame)) {
+					gp->panic = gp->panic->link;
+				}
+				// return from the function with the frame f
+				longjmp(f->jmp, 1);
+			}
+		}
+		gp->frames = f->parent;
+		free(f);
+	}
+	fatalpanic(gp, &p);
+}
+
+// goal_recover stops the current panic, returning its value, when
+// called by a deferred call run by the panic, and returns nil
+// otherwise. Unlike Go, the deferred function can call it indirectly.
+goal_iface goal_recover(void) {
+	goal_g *gp = goal_getg();
+	goal_panicking *p = gp->panic;
+	goal_iface res = {NULL, NULL};
+	if (p != NULL && !p->recovered && gp->deferframe == p->frame) {
+		p->recovered = 1;
+		res = p->value;
+	}
+	return res;
+}
@@ -0,0 +1,121 @@
+// Goroutines and the blocking of goroutines.
+//
+// Each goroutine runs on a thread of its own. Goroutines blocked in
+// channel operations park on their condition variable, under the
+// runtime lock, until readied by another goroutine. The program is
+// deadlocked when all the goroutines are parked.
+
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+#include "runtime.h"
+
+// guards the channels and the counters below
+static pthread_mutex_t lock = PTHREAD_MUTEX_INITIALIZER;
+
+// the main goroutine is counted from the start
+static int64_t ngoroutines = 1;
+static int64_t nparked;
+static int64_t lastid = 1;
+
+static _Thread_local goal_g *curg;
+
+static goal_g *newg(int64_t id) {
+	goal_g *gp = goal_alloc(1, sizeof(goal_g));
+	gp->id = id;
+	pthread_cond_init(&gp->park, NULL);
+	return gp;
+}
+
+// goal_getg returns the state of the running goroutine,
+// creating the one of the main goroutine on first use.
+goal_g *goal_getg(void) {
+	if (curg == NULL) {
+		curg = newg(1);
+	}
+	return curg;
+}
+
+void goal_lock(void) {
+	pthread_mutex_lock(&lock);
+}
+
+void goal_unlock(void) {
+	pthread_mutex_unlock(&lock);
+}
+
+// checkdead exits the program if all the goroutines are parked.
+static void checkdead(void) {
+	if (ngoroutines > 0 && nparked == ngoroutines) {
+		fprintf(stderr, "fatal error: all goroutines are asleep - deadlock!\n");
+		exit(2);
+	}
+}
+
+// goal_park blocks the running goroutine, with the runtime
+// lock held, until p is readied by another goroutine.
+void goal_park(goal_parking *p) {
+	p->g = goal_getg();
+	nparked++;
+	checkdead();
+	while (!p->done) {
+		pthread_cond_wait(&p->g->park, &lock);
+	}
+}
+
+// goal_ready wakes up the goroutine parked on p, with the runtime lock
+// held, setting p->index. It returns 0 if p was already readied.
+int goal_ready(goal_parking *p, int64_t index) {
+	if (p->done) {
+		return 0;
+	}
+	p->done = 1;
+	p->index = index;
+	// counted as running from now, even if it is yet to wake up
+	nparked--;
+	pthread_cond_signal(&p->g->park);
+	return 1;
+}
+
+typedef struct {
+	void (*fn)(void *env);
+	void *env;
+	goal_g *g;
+} gostart;
+
+// startg runs a new goroutine on its thread.
+static void *startg(void *arg) {
+	gostart *start = arg;
+	curg = start->g;
+	start->fn(start->env);
+	free(start);
+
+	goal_lock();
+	ngoroutines--;
+	checkdead();
+	goal_unlock();
+	return NULL;
+}
+
+// goal_go starts a goroutine calling fn with the environment env.
+void goal_go(void (*fn)(void *), void *env) {
+	gostart *start = goal_alloc(1, sizeof(gostart));
+	start->fn = fn;
+	start->env = env;
+
+	goal_lock();
+	start->g = newg(++lastid);
+	ngoroutines++;
+	goal_unlock();
+
+	pthread_attr_t attr;
+	pthread_t thread;
+	pthread_attr_init(&attr);
+	pthread_attr_setdetachstate(&attr, PTHREAD_CREATE_DETACHED);
+	if (pthread_create(&thread, &attr, startg, start) != 0) {
+		fprintf(stderr, "fatal error: cannot create goroutine\n");
+		exit(2);
+	}
+	pthread_attr_destroy(&attr);
+}
@@ -0,0 +1,52 @@
+// Runtime support for programs compiled by glc.
+//
+// Compiled programs call into the functions defined in this
+// directory, which must be linked in; see build.sh.
+
+#include <stdio.h>
+#include <stdlib.h>
+
+#include "runtime.h"
+
+// goal_panic_index reports an index out of range,
+// at the source position pos.
+void goal_panic_index(const char *pos, int64_t index, int64_t len) {
+	goal_panic_error(pos, "runtime error: index out of range [%lld] with length %lld",
+		(long long)index, (long long)len);
+}
+
+// goal_panic_slice reports slice bounds out of range.
+void goal_panic_slice(const char *pos, int64_t lo, int64_t hi, int64_t max, int64_t cap) {
+	goal_panic_error(pos, "runtime error: slice bounds out of range [%lld:%lld:%lld] with capacity %lld",
+		(long long)lo, (long long)hi, (long long)max, (long long)cap);
+}
+
+// goal_panic_nil reports a dereference of a nil pointer.
+void goal_panic_nil(const char *pos) {
+	goal_panic_error(pos, "runtime error: invalid memory address or nil pointer dereference");
+}
+
+// goal_panic_divide reports an integer division by zero.
+void goal_panic_divide(const char *pos) {
+	goal_panic_error(pos, "runtime error: integer divide by zero");
+}
+
+// goal_panic_message reports a runtime error detected by the runtime.
+void goal_panic_message(const char *msg) {
+	goal_panic_error(NULL, "runtime error: %s", msg);
+}
+
+// goal_alloc allocates n zeroed elements of elemsize bytes.
+// Memory is never freed.
+void *goal_alloc(int64_t n, int64_t elemsize) {
+	if (n == 0 || elemsize == 0) {
+		// distinct allocations must have distinct addresses
+		return calloc(1, 1);
+	}
+	void *res = calloc(n, elemsize);
+	if (res == NULL) {
+		fprintf(stderr, "fatal error: out of memory\n");
+		exit(2);
+	}
+	return res;
+}
@@ -0,0 +1,123 @@
+// Declarations shared by the runtime support of glc programs.
+
+#ifndef GOAL_RUNTIME_H
+#define GOAL_RUNTIME_H
+
+#include <pthread.h>
+#include <stdint.h>
+
+// goal_int is the representation of the Go int type.
+typedef int32_t goal_int;
+
+#define GOAL_INT_MAX INT32_MAX
+
+// goal_slice matches the { T*, int, int } slice header.
+typedef struct {
+	void *ptr;
+	goal_int len;
+	goal_int cap;
+} goal_slice;
+
+// goal_string matches the { i8*, int } string header.
+typedef struct {
+	const char *ptr;
+	goal_int len;
+} goal_string;
+
+typedef struct goal_map goal_map;
+typedef struct goal_mapiter goal_mapiter;
+typedef struct goal_chan goal_chan;
+
+// goal_method is an entry of the method table of a type, keyed
+// by the name and the signature of the method, as in "Error() string".
+typedef struct {
+	const char *name;
+	void *fn;
+} goal_method;
+
+// goal_type describes the dynamic type of interface values.
+typedef struct {
+	const char *name;
+	int64_t size;
+	// pointers are held directly in the data word of
+	// interface values, which point to copies of other values
+	int64_t direct;
+	// the layout compared by ==, as described in map.c,
+	// with nfields -1 for types which are not comparable
+	const int64_t *fields;
+	int64_t nfields;
+	// sorted by name
+	const goal_method *methods;
+	int64_t nmethods;
+} goal_type;
+
+// goal_interfacetype lists the keys of the methods of
+// an interface type, as in goal_method, sorted by name.
+typedef struct {
+	const char *name;
+	const char *const *methods;
+	int64_t nmethods;
+} goal_interfacetype;
+
+// goal_itab holds the dynamic type of an interface value and the
+// methods implementing those of the interface, in order.
+typedef struct {
+	const goal_type *type;
+	void *fun[];
+} goal_itab;
+
+// goal_iface matches the { itab, data } interface values.
+typedef struct {
+	const goal_itab *itab;
+	void *data;
+} goal_iface;
+
+typedef struct goal_frame goal_frame;
+typedef struct goal_panicking goal_panicking;
+
+// goal_g is the state of a goroutine.
+typedef struct {
+	int64_t id;
+	// frames of the functions with deferred calls, the innermost
+	// first, and the one whose deferred call is running, if any
+	goal_frame *frames;
+	goal_frame *deferframe;
+	// innermost panic, if any
+	goal_panicking *panic;
+	// signaled when the goroutine is readied
+	pthread_cond_t park;
+} goal_g;
+
+// goal_parking is a goroutine blocked until readied by another one,
+// which sets index, as for the case of a select statement.
+typedef struct {
+	goal_g *g;
+	int done;
+	int64_t index;
+} goal_parking;
+
+goal_g *goal_getg(void);
+
+void goal_lock(void);
+void goal_unlock(void);
+void goal_park(goal_parking *p);
+int goal_ready(goal_parking *p, int64_t index);
+
+void goal_panic(const goal_itab *itab, void *data) __attribute__((noreturn));
+void goal_panic_error(const char *pos, const char *format, ...) __attribute__((noreturn, format(printf, 2, 3)));
+void goal_panic_index(const char *pos, int64_t index, int64_t len) __attribute__((noreturn));
+void goal_panic_slice(const char *pos, int64_t lo, int64_t hi, int64_t max, int64_t cap) __attribute__((noreturn));
+void goal_panic_nil(const char *pos) __attribute__((noreturn));
+void goal_panic_divide(const char *pos) __attribute__((noreturn));
+void goal_panic_message(const char *msg) __attribute__((noreturn));
+
+void *goal_alloc(int64_t n, int64_t elemsize);
+
+const goal_type *goal_itabtype(const goal_itab *tab);
+
+uint64_t goal_hashfields(uint64_t h, const int64_t *fields, int64_t nfields, const char *p);
+int goal_equalfields(const int64_t *fields, int64_t nfields, const char *a, const char *b);
+uint64_t goal_ifacehash(uint64_t h, const goal_iface *x);
+int64_t goal_ifaceeq(const goal_itab *xtab, void *x, const goal_itab *ytab, void *y);
+
+#endif
@@ -0,0 +1,52 @@
+// Backing arrays of slices.
+
+#include <string.h>
+
+#include "runtime.h"
+
+// goal_makeslice allocates the zeroed backing array of make([]T, len, cap).
+void *goal_makeslice(int64_t len, int64_t cap, int64_t elemsize) {
+	if (len < 0 || len > GOAL_INT_MAX) {
+		goal_panic_message("makeslice: len out of range");
+	}
+	if (cap < len || cap > GOAL_INT_MAX) {
+		goal_panic_message("makeslice: cap out of range");
+	}
+	return goal_alloc(cap, elemsize);
+}
+
+// goal_growslice makes room for n more elements in s, moving the
+// elements to a new backing array if the capacity is not enough.
+// The length of s is left unchanged.
+void goal_growslice(goal_slice *s, int64_t n, int64_t elemsize) {
+	int64_t needed = (int64_t)s->len + n;
+	if (needed <= s->cap) {
+		return;
+	}
+	if (needed > GOAL_INT_MAX) {
+		goal_panic_message("growslice: len out of range");
+	}
+	int64_t cap = 2 * (int64_t)s->cap;
+	if (cap < needed) {
+		cap = needed;
+	}
+	if (cap > GOAL_INT_MAX) {
+		cap = GOAL_INT_MAX;
+	}
+	void *ptr = goal_alloc(cap, elemsize);
+	if (s->len > 0) {
+		memcpy(ptr, s->ptr, s->len * elemsize);
+	}
+	s->ptr = ptr;
+	s->cap = (goal_int)cap;
+}
+
+// goal_slicecopy copies min(dstlen, srclen) elements from src to dst,
+// which can overlap, and returns the number of elements copied.
+int64_t goal_slicecopy(void *dst, int64_t dstlen, void *src, int64_t srclen, int64_t elemsize) {
+	int64_t n = dstlen < srclen ? dstlen : srclen;
+	if (n > 0) {
+		memmove(dst, src, n * elemsize);
+	}
+	return n;
+}
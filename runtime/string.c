@@ -0,0 +1,170 @@
+// Operations on strings, passed as pointer and length pairs.
+//
+// The bytes of a string are never modified once the string
+// is built, so that strings can share them.
+
+#include <string.h>
+
+#include "runtime.h"
+
+#define RUNE_ERROR 0xFFFD
+#define MAX_RUNE 0x10FFFF
+
+static void check_len(int64_t len) {
+	if (len > GOAL_INT_MAX) {
+		goal_panic_message("string length overflows int");
+	}
+}
+
+// goal_concatstrings returns the bytes of a+b, whose length
+// is alen+blen.
+char *goal_concatstrings(const char *a, int64_t alen, const char *b, int64_t blen) {
+	check_len(alen + blen);
+	char *res = goal_alloc(alen + blen, 1);
+	memcpy(res, a, alen);
+	memcpy(res + alen, b, blen);
+	return res;
+}
+
+// goal_cmpstring compares two strings bytewise, returning
+// a negative, zero or positive value if a < b, a == b or a > b.
+int64_t goal_cmpstring(const char *a, int64_t alen, const char *b, int64_t blen) {
+	int64_t n = alen < blen ? alen : blen;
+	int c = memcmp(a, b, n);
+	if (c != 0) {
+		return c;
+	}
+	return alen - blen;
+}
+
+// goal_slicebytetostring copies the bytes of string(b).
+char *goal_slicebytetostring(const uint8_t *ptr, int64_t len) {
+	char *res = goal_alloc(len, 1);
+	memcpy(res, ptr, len);
+	return res;
+}
+
+// goal_stringtoslicebyte copies the bytes of []byte(s).
+uint8_t *goal_stringtoslicebyte(const char *s, int64_t len) {
+	uint8_t *res = goal_alloc(len, 1);
+	memcpy(res, s, len);
+	return res;
+}
+
+// decoderune decodes the UTF-8 sequence at s[i], storing the rune
+// in r and returning the index of the next sequence. Invalid
+// sequences decode to RUNE_ERROR and consume one byte.
+static int64_t decoderune(const uint8_t *s, int64_t len, int64_t i, int32_t *r) {
+	uint8_t c = s[i];
+	if (c < 0x80) {
+		*r = c;
+		return i + 1;
+	}
+
+	int n;
+	int32_t min;
+	if ((c & 0xE0) == 0xC0) {
+		n = 1;
+		min = 0x80;
+		*r = c & 0x1F;
+	} else if ((c & 0xF0) == 0xE0) {
+		n = 2;
+		min = 0x800;
+		*r = c & 0x0F;
+	} else if ((c & 0xF8) == 0xF0) {
+		n = 3;
+		min = 0x10000;
+		*r = c & 0x07;
+	} else {
+		*r = RUNE_ERROR;
+		return i + 1;
+	}
+	if (i + n >= len) {
+		*r = RUNE_ERROR;
+		return i + 1;
+	}
+	for (int k = 1; k <= n; k++) {
+		if ((s[i + k] & 0xC0) != 0x80) {
+			*r = RUNE_ERROR;
+			return i + 1;
+		}
+		*r = (*r << 6) | (s[i + k] & 0x3F);
+	}
+	if (*r < min || *r > MAX_RUNE || (*r >= 0xD800 && *r <= 0xDFFF)) {
+		*r = RUNE_ERROR;
+		return i + 1;
+	}
+	return i + n + 1;
+}
+
+// goal_countrunes returns the number of runes in s.
+int64_t goal_countrunes(const char *s, int64_t len) {
+	int64_t n = 0;
+	int32_t r;
+	for (int64_t i = 0; i < len; n++) {
+		i = decoderune((const uint8_t *)s, len, i, &r);
+	}
+	return n;
+}
+
+// goal_stringtoslicerune decodes the n runes of []rune(s),
+// where n was computed by goal_countrunes.
+int32_t *goal_stringtoslicerune(const char *s, int64_t len, int64_t n) {
+	int32_t *res = goal_alloc(n, sizeof(int32_t));
+	for (int64_t i = 0, k = 0; i < len; k++) {
+		i = decoderune((const uint8_t *)s, len, i, &res[k]);
+	}
+	return res;
+}
+
+// encoderune writes the UTF-8 encoding of r to buf, if not NULL,
+// and returns its length. Invalid runes encode as RUNE_ERROR.
+static int encoderune(uint8_t *buf, int32_t r) {
+	if (r < 0 || r > MAX_RUNE || (r >= 0xD800 && r <= 0xDFFF)) {
+		r = RUNE_ERROR;
+	}
+	uint8_t tmp[4];
+	if (buf == NULL) {
+		buf = tmp;
+	}
+	if (r < 0x80) {
+		buf[0] = r;
+		return 1;
+	}
+	if (r < 0x800) {
+		buf[0] = 0xC0 | (r >> 6);
+		buf[1] = 0x80 | (r & 0x3F);
+		return 2;
+	}
+	if (r < 0x10000) {
+		buf[0] = 0xE0 | (r >> 12);
+		buf[1] = 0x80 | ((r >> 6) & 0x3F);
+		buf[2] = 0x80 | (r & 0x3F);
+		return 3;
+	}
+	buf[0] = 0xF0 | (r >> 18);
+	buf[1] = 0x80 | ((r >> 12) & 0x3F);
+	buf[2] = 0x80 | ((r >> 6) & 0x3F);
+	buf[3] = 0x80 | (r & 0x3F);
+	return 4;
+}
+
+// goal_encodedlen returns the length of the UTF-8 encoding of n runes.
+int64_t goal_encodedlen(const int32_t *runes, int64_t n) {
+	int64_t len = 0;
+	for (int64_t k = 0; k < n; k++) {
+		len += encoderune(NULL, runes[k]);
+	}
+	check_len(len);
+	return len;
+}
+
+// goal_slicerunetostring encodes the n runes of string(runes),
+// where len was computed by goal_encodedlen.
+char *goal_slicerunetostring(const int32_t *runes, int64_t n, int64_t len) {
+	uint8_t *res = goal_alloc(len, 1);
+	for (int64_t k = 0, i = 0; k < n; k++) {
+		i += encoderune(res + i, runes[k]);
+	}
+	return (char *)res;
+}
@@ -0,0 +1,61 @@
+package main
+
+const N = 4
+
+type Grid [N][N]int
+
+type Buf struct {
+	data [8]int8
+	n    int
+}
+
+var squares = [...]int{1, 4, 9, 16, 25}
+
+var primes [N]int
+
+func Trace(g Grid) int {
+	t := 0
+	for i := 0; i < len(g); i++ {
+		t += g[i][i]
+	}
+	return t
+}
+
+func Put(b *Buf, c int8) {
+	b.data[b.n] = c
+	b.n++
+}
+
+func Get(i int) int {
+	return squares[i]
+}
+
+func main() int {
+	var g Grid
+	for i := 0; i < N; i++ {
+		for j := 0; j < N; j++ {
+			g[i][j] = i*N + j
+		}
+	}
+	h := g
+	h[1][1] = 100
+	if g[1][1] != 5 {
+		return 1
+	}
+	if g == h {
+		return 2
+	}
+	h[1][1] = 5
+	if g != h {
+		return 3
+	}
+	var b Buf
+	Put(&b, 3)
+	Put(&b, 4)
+	p := &primes
+	p[0], p[1], primes[2], (*p)[3] = 2, 3, 5, 7
+	arr := [3]int{2: 10, 0: 1}
+	pts := [2]*Buf{{n: 1}, {n: 2}}
+	x := Trace(g) + int(b.data[0]*b.data[1]) + len(squares) + primes[3]
+	return x + arr[2] + arr[1] + pts[1].n + Get(len(arr)+1)
+}
@@ -0,0 +1,53 @@
+package main
+
+func putchar(c int32) int32
+
+func main() int32 {
+	PrintDigits(Fib(10))
+	putchar(10)
+	return Even(10) + Fact(5)
+}
+
+func PrintDigits(x int64) {
+	if x > 9 {
+		PrintDigits(x / 10)
+	}
+	putchar(int32Digit(x % 10))
+}
+
+func int32Digit(d int64) int32 {
+	var r int32
+	var i int64
+	for i = 0; i < d; i++ {
+		r++
+	}
+	return r + 48
+}
+
+func Fib(n int64) int64 {
+	if n < 2 {
+		return n
+	}
+	return Fib(n-1) + Fib(n-2)
+}
+
+func Even(e int32) int32 {
+	if e < 1 {
+		return 1
+	}
+	return Odd(e - 1)
+}
+
+func Odd(o int32) int32 {
+	if o < 1 {
+		return 0
+	}
+	return Even(o - 1)
+}
+
+func Fact(f int32) int32 {
+	if f < 2 {
+		return 1
+	}
+	return f * Fact(f-1)
+}
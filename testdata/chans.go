@@ -0,0 +1,86 @@
+package main
+
+type Result struct {
+	Id, Value int
+}
+
+func produce(n int, out chan<- int) {
+	for i := 1; i < n+1; i++ {
+		out <- i
+	}
+	close(out)
+}
+
+func square(in <-chan int, out chan<- Result, id int) {
+	for x := range in {
+		out <- Result{id, x * x}
+	}
+	out <- Result{id, 0}
+}
+
+func sum(xs []int, res chan int) {
+	s := 0
+	for _, x := range xs {
+		s += x
+	}
+	res <- s
+}
+
+func main() int {
+	// unbuffered channels synchronize the goroutines
+	res := make(chan int)
+	xs := []int{1, 2, 3, 4, 5, 6}
+	go sum(xs[:3], res)
+	go sum(xs[3:], res)
+	s := <-res + <-res // 21
+
+	// a pipeline of goroutines, ended by closing the channels
+	nums := make(chan int)
+	results := make(chan Result, 4)
+	go produce(4, nums)
+	go square(nums, results, 1)
+	go square(nums, results, 2)
+	done := 0
+	total := 0
+	for done < 2 {
+		r := <-results
+		if r.Value == 0 {
+			done++
+		}
+		total += r.Value
+	}
+	s += total // 51
+
+	// buffered channels
+	buf := make(chan string, 3)
+	buf <- "a"
+	buf <- "bc"
+	s += len(buf) + cap(buf) // 56
+	s += len(<-buf + <-buf)  // 59
+	close(buf)
+	v, ok := <-buf
+	if ok == false {
+		if v == "" {
+			s++ // 60
+		}
+	}
+
+	// goroutines started by function literals capture variables
+	counter := 0
+	ack := make(chan bool)
+	for i := 0; i < 5; i++ {
+		go func(n int) {
+			ack <- n%2 == 0
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		if <-ack {
+			counter++
+		}
+	}
+	s += counter // 63
+
+	var nilch chan int
+	s += len(nilch) + cap(nilch) // 63
+	return s
+}
@@ -0,0 +1,104 @@
+package main
+
+type Op func(int, int) int
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) Sum() int {
+	return p.X + p.Y
+}
+
+func (p *Point) Move(dx int) {
+	p.X += dx
+}
+
+type Handler struct {
+	Name string
+	Fn   func(int) int
+}
+
+func add(a, b int) int {
+	return a + b
+}
+
+func apply(op Op, a, b int) int {
+	return op(a, b)
+}
+
+func counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func adder(base int) func(int) int {
+	return func(x int) int {
+		return base + x
+	}
+}
+
+func main() int {
+	c := counter()
+	c()
+	c()
+	s := c() // 3
+
+	s += apply(add, 1, 2)                                 // 6
+	s += apply(func(a, b int) int { return a * b }, 2, 3) // 12
+	s += adder(10)(5)                                     // 27
+
+	total := 0
+	for i := 0; i < 4; i++ {
+		func() {
+			total += i
+		}()
+	}
+	s += total // 33
+
+	// each iteration captures its own i
+	var fs []func() int
+	for i := 0; i < 4; i++ {
+		fs = append(fs, func() int { return i })
+		if i == 1 {
+			i++
+		}
+	}
+	for _, f := range fs {
+		s += f() // 33+0+2+3 = 38
+	}
+
+	var f func() int
+	if f == nil {
+		s++ // 39
+	}
+	f = c
+	s += f() // 43
+
+	h := Handler{"double", func(x int) int { return 2 * x }}
+	s += h.Fn(3) // 49
+
+	p := Point{1, 2}
+	sum := p.Sum
+	move := p.Move
+	move(2)
+	s += sum() + p.X // 55
+
+	ps := (*Point).Sum
+	s += Point.Sum(p) + ps(&p) // 65
+
+	var nested func() int
+	x := 1
+	nested = func() int {
+		inc := func() {
+			x *= 2
+		}
+		inc()
+		inc()
+		return x
+	}
+	return s + nested() - x // 65
+}
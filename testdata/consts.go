@@ -0,0 +1,40 @@
+package main
+
+const (
+	KB = 1 << (10 * (iota + 1))
+	MB
+	GB
+)
+
+const Big = 1 << 100
+
+const Small = Big >> 98
+
+const (
+	A int8 = iota * 10
+	B
+	C
+	_
+	E
+)
+
+const Later = Early + 1
+
+const Early = 41
+
+const Truth = 1 < 2 && !false
+
+const Hello = "hello, " + "world"
+
+func Fold() int64 {
+	const local = KB * 3
+	var x int64 = local + 1 + 2
+	if Truth {
+		x = x + GB/MB
+	}
+	return x
+}
+
+func main() int {
+	return Later + Small + int(E) + int(Fold()%100)
+}
@@ -0,0 +1,148 @@
+package main
+
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+var trace []int
+
+func record(x int) {
+	trace = append(trace, x)
+}
+
+// deferred calls run last in first out, with the
+// arguments evaluated by the defer statements
+func order() {
+	for i := 0; i < 3; i++ {
+		defer record(i)
+	}
+	x := 10
+	defer record(x)
+	x = 20
+}
+
+// deferred closures can modify the named results
+func double(x int) (res int) {
+	defer func() {
+		res *= 2
+	}()
+	return x + 1
+}
+
+func safeDiv(a, b int) (q int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	return a / check(b), nil
+}
+
+func check(b int) int {
+	if b == 0 {
+		panic(&Error{"division by zero"})
+	}
+	return b
+}
+
+func index(xs []int, i int) (res int) {
+	defer func() {
+		if recover() != nil {
+			res = -1
+		}
+	}()
+	return xs[i]
+}
+
+// panics while running deferred calls replace the current one
+func repanic() (res string) {
+	defer func() {
+		res = recover().(string)
+	}()
+	defer func() {
+		panic("second")
+	}()
+	panic("first")
+}
+
+func noPanic() bool {
+	r := 1
+	defer func() {
+		r = 2
+		if recover() == nil {
+			r = 3
+		}
+	}()
+	return r == 1
+}
+
+type Node struct {
+	next *Node
+	val  int
+}
+
+func (n Node) Val() int {
+	return n.val
+}
+
+// nil pointer dereferences and divisions by zero
+// panic with recoverable runtime errors
+func runtimeError(f func() int) (msg string) {
+	defer func() {
+		msg = recover().(error).Error()
+	}()
+	f()
+	return ""
+}
+
+func main() int {
+	order()
+	s := 0
+	for _, x := range trace {
+		s = s*10 + x
+	}
+	s = s % 1000 // 210 from 10210
+
+	s += double(4) // 220
+
+	q, err := safeDiv(7, 2)
+	s += q // 223
+	if err == nil {
+		s++ // 224
+	}
+	_, err = safeDiv(1, 0)
+	if err != nil {
+		if err.Error() == "division by zero" {
+			s++ // 225
+		}
+	}
+
+	s += index([]int{1, 2}, 1) + index([]int{1, 2}, 5) // 226
+	if repanic() == "second" {
+		s++ // 227
+	}
+	if noPanic() {
+		s++ // 228
+	}
+
+	const nilDeref = "runtime error: invalid memory address or nil pointer dereference"
+	var p *Node
+	if runtimeError(func() int { return p.val }) == nilDeref {
+		s++ // 229
+	}
+	if runtimeError(func() int { *p = Node{}; return 0 }) == nilDeref {
+		s++ // 230
+	}
+	if runtimeError(func() int { return p.Val() }) == nilDeref {
+		s++ // 231
+	}
+	zero := 0
+	if runtimeError(func() int { return 7 % zero }) == "runtime error: integer divide by zero" {
+		s++ // 232
+	}
+	return s - 200
+}
@@ -0,0 +1,19 @@
+package main
+
+func Pair(p int64) (int64, int64) {
+	return p, p * 2
+}
+
+func main() int {
+	x := 40
+	var y = x + 2
+	a, b := Pair(5)
+	a, c := Pair(b)
+	var s, t = 3, 4
+	z, _ := Pair(1)
+	for i := 0; i < 3; i++ {
+		y++
+	}
+	x, y = y, x
+	return x + s + t + int(a+c+z)
+}
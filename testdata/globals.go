@@ -0,0 +1,55 @@
+package main
+
+var a = b + c
+var b = f()
+var c int = 3
+var d, e int
+var counter int
+
+// strings are initialized statically
+var greeting = "hello"
+var lang Name = "go"
+
+// initialized after the variables read by the methods they call
+var got = T{}.Get()
+var half = tv.Half
+var halved = half()
+var later = 5
+var tv = T{8}
+
+type Name string
+
+type T struct {
+	n int
+}
+
+func (t T) Get() int {
+	return later * 2
+}
+
+func (t T) Half() int {
+	return t.n / 2
+}
+
+func f() int {
+	d = 4
+	return c * 2
+}
+
+func bump() int {
+	counter = counter + 1
+	return counter
+}
+
+func init() {
+	e = a + d
+}
+
+func init() {
+	bump()
+}
+
+func main() int {
+	bump()
+	return e*10 + counter + got + halved + len(greeting) + len(lang)
+}
@@ -0,0 +1,129 @@
+package main
+
+type Shape interface {
+	Area() int
+	Name() string
+}
+
+type Rect struct {
+	W, H int
+}
+
+func (r Rect) Area() int {
+	return r.W * r.H
+}
+
+func (r Rect) Name() string {
+	return "rect"
+}
+
+type Square struct {
+	Side int
+}
+
+func (s *Square) Area() int {
+	return s.Side * s.Side
+}
+
+func (s *Square) Name() string {
+	return "square"
+}
+
+func (s *Square) Grow() {
+	s.Side++
+}
+
+type Grower interface {
+	Grow()
+}
+
+type MyError struct {
+	Code int
+}
+
+func (e MyError) Error() string {
+	return "my error"
+}
+
+func fail(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return MyError{code}
+}
+
+func total(shapes []Shape) int {
+	sum := 0
+	for _, s := range shapes {
+		sum += s.Area()
+	}
+	return sum
+}
+
+func classify(x interface{}) int {
+	switch v := x.(type) {
+	case nil:
+		return 1
+	case int:
+		return v * 10
+	case string:
+		return len(v)
+	case Shape:
+		return v.Area()
+	case bool, Rect:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func main() int {
+	sq := &Square{3}
+	shapes := []Shape{Rect{2, 3}, sq}
+	res := total(shapes) // 15
+
+	var g Grower = sq
+	g.Grow()
+	res += shapes[1].Area() - 16 // 15
+
+	if g2, ok := shapes[1].(Grower); ok {
+		g2.Grow()
+	}
+	res += sq.Side // 20
+
+	if _, ok := shapes[0].(Grower); ok {
+		res += 1000
+	}
+	r := shapes[0].(Rect)
+	res += r.W // 22
+
+	err := fail(0)
+	if err == nil {
+		res++ // 23
+	}
+	err = fail(7)
+	if err != nil {
+		res += len(err.Error()) // 31
+	}
+	if me, ok := err.(MyError); ok {
+		res += me.Code // 38
+	}
+
+	var e interface{} = 5
+	if e == 5 {
+		res++ // 39
+	}
+	var e2 interface{} = "x"
+	if e == e2 {
+		res += 1000
+	}
+	m := map[interface{}]int{}
+	m[1] = 2
+	m["a"] = 3
+	m[Rect{1, 1}] = 4
+	res += m[1] + m["a"] + m[Rect{1, 1}] // 48
+
+	res += classify(nil) + classify(2) + classify("abc") + classify(sq) + classify(true) + classify(Rect{}) + classify(m)
+	// 1 + 20 + 3 + 25 + 3 + 0 + 4 = 56, Rect is a Shape
+	return res - 97 // 7
+}
@@ -0,0 +1,33 @@
+package main
+
+const raw = `a\n"b"`
+
+func main() int {
+	s := "\t\x41é\"\\\n\000"
+	if len(s) != 8 {
+		return 1
+	}
+	if s[0] != '\t' {
+		return 2
+	}
+	if s[1] != 'A' {
+		return 3
+	}
+	if s[7] != 0 {
+		return 4
+	}
+	if len(raw) != 6 {
+		return 5
+	}
+	if raw[1] != '\\' {
+		return 6
+	}
+	r := []rune("é")
+	if r[0] != 'é' {
+		return 7
+	}
+	var b byte = 'z' - 'a'
+	c := 'x' + 1
+	var i int32 = c
+	return int(b) + int(i-'y') + len(raw)
+}
@@ -0,0 +1,65 @@
+package main
+
+func Sum(n int64) int64 {
+	var s int64 = 0
+	var i int64
+	for i = 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
+
+func While(m int64) int64 {
+	var c int64
+	for m > 1 {
+		if m%2 > 0 {
+			m = 3*m + 1
+		} else {
+			m = m / 2
+		}
+		c++
+	}
+	return c
+}
+
+func Inf(k int64) int64 {
+	var ii int64
+	for {
+		ii++
+		if ii > k {
+			break
+		}
+		if ii%2 > 0 {
+			continue
+		}
+		k = k - 1
+	}
+	return ii
+}
+
+func Nested(q int64) int64 {
+	var t int64
+	var i2 int64
+	var j int64
+outer:
+	for i2 = 0; i2 < q; i2++ {
+		for j = 0; j < q; j++ {
+			if j > i2 {
+				continue outer
+			}
+			if i2 > 5 {
+				break outer
+			}
+			t = t + j
+		}
+	}
+	return t
+}
+
+func main() int {
+	s := Sum(10)   // 45
+	s += While(6)  // 53
+	s += Inf(10)   // 61
+	s += Nested(4) // 71
+	return int(s)
+}
@@ -0,0 +1,94 @@
+package main
+
+type Point struct {
+	X, Y int8
+	Name string
+}
+
+func Histogram(words []string) map[string]int {
+	h := make(map[string]int)
+	for _, w := range words {
+		h[w]++
+	}
+	return h
+}
+
+func main() int {
+	h := Histogram([]string{"a", "b", "a", "c", "a", "b"})
+	if len(h) != 3 {
+		return 1
+	}
+	if h["a"] != 3 {
+		return 2
+	}
+	if h["zzz"] != 0 {
+		return 3
+	}
+	n, ok := h["b"]
+	if ok == false {
+		return 4
+	}
+	_, ok = h["nope"]
+	if ok {
+		return 5
+	}
+	delete(h, "a")
+	delete(h, "missing")
+	if len(h) != 2 {
+		return 6
+	}
+
+	squares := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		squares[i] = i * i
+	}
+	for i := 0; i < 1000; i += 2 {
+		delete(squares, i)
+	}
+	sum := 0
+	count := 0
+	for k, v := range squares {
+		if v != k*k {
+			return 7
+		}
+		sum += k
+		count++
+	}
+	if count != 500 {
+		return 8
+	}
+	if sum != 250000 {
+		return 9
+	}
+
+	points := map[Point]bool{{1, 2, "p"}: true}
+	points[Point{3, 4, "q"}] = true
+	name := "p"
+	if points[Point{1, 2, name}] == false {
+		return 10
+	}
+	if points[Point{1, 2, "x"}] {
+		return 11
+	}
+
+	var nilMap map[string]int
+	if nilMap != nil {
+		return 12
+	}
+	if nilMap["x"] != 0 {
+		return 13
+	}
+	for range nilMap {
+		return 14
+	}
+
+	arr := [3]int{1, 2, 3}
+	total := 0
+	for i, x := range arr {
+		total += i * x
+	}
+	var k string
+	for k = range h {
+	}
+	return n + total + len(points) + len(k)
+}
@@ -0,0 +1,46 @@
+package main
+
+type Point struct {
+	X, Y int
+}
+
+func (p Point) Sum() int {
+	return p.X + p.Y
+}
+
+func (p *Point) Move(dx, dy int) {
+	p.X += dx
+	p.Y += dy
+}
+
+func (p Point) Scaled(k int) Point {
+	return Point{p.X * k, p.Y * k}
+}
+
+type Counter int
+
+func (c *Counter) Incr() {
+	*c = *c + 1
+}
+
+func (c Counter) Value() int {
+	return int(c)
+}
+
+func main() int {
+	p := Point{1, 2}
+	p.Move(1, 1)
+	q := &p
+	q.Move(1, 0)
+	s := q.Sum() + p.Scaled(2).Sum()
+
+	var c Counter
+	for i := 0; i < 3; i++ {
+		c.Incr()
+	}
+	s += c.Value()
+
+	s += Point.Sum(p) + (*Point).Sum(q)
+	(*Point).Move(q, 0, 1)
+	return s + p.Y
+}
@@ -0,0 +1,35 @@
+package main
+
+func DivMod(a, b int64) (int64, int64) {
+	return a / b, a % b
+}
+
+func Swap(x, y int64) (int64, int64) {
+	return y, x
+}
+
+func Named(n int64) (q int64, r int64) {
+	if n > 10 {
+		q = n / 10
+		r = n % 10
+		return
+	}
+	return 0, n
+}
+
+func Forward(m int64) (int64, int64) {
+	return DivMod(m, 7)
+}
+
+func Combine(hi, lo int64) int64 {
+	return hi*1000 + lo
+}
+
+func main() int64 {
+	var p, w int64 = DivMod(47, 5)
+	s, t := Swap(p, w)
+	s, _ = Swap(s, t)
+	var u int64
+	u, s = Named(95)
+	return Combine(Forward(100)) + Combine(s, u)*0 + Combine(p, w) - 9002 + s*100 + u
+}
@@ -0,0 +1,81 @@
+package main
+
+type Flag bool
+
+var calls int
+
+func touch(b bool) bool {
+	calls++
+	return b
+}
+
+func inRange(x, lo, hi int) bool {
+	return x >= lo && x <= hi
+}
+
+func main() int {
+	s := 0
+
+	// the right operands are evaluated only when needed
+	if touch(false) && touch(true) {
+		s += 100
+	}
+	if touch(true) || touch(false) {
+		s++ // 1
+	}
+	if touch(false) || touch(true) && touch(true) {
+		s++ // 2
+	}
+	s += calls // 7
+
+	var xs []int
+	if len(xs) > 0 && xs[0] == 1 {
+		s += 100
+	}
+	if xs == nil || xs[0] == 1 {
+		s++ // 8
+	}
+
+	if inRange(5, 1, 5) && !inRange(6, 1, 5) && inRange(1, 1, 1) {
+		s++ // 9
+	}
+
+	ok := true
+	if ok == true && !(ok != true) {
+		s++ // 10
+	}
+	var g Flag = true
+	f := g && true
+	if !f == false {
+		s++ // 11
+	}
+
+	x := 7
+	s += -x + +x + x // 18
+	s += ^x + 10     // 20
+	var b byte = 5
+	if ^b == 250 {
+		s++ // 21
+	}
+	var u uint8 = 200
+	if u > 100 && u >= 200 && u <= 200 {
+		s++ // 22
+	}
+	if -x < 0 && x-8 <= -1 {
+		s++ // 23
+	}
+
+	a, c := "apple", "banana"
+	if a <= c && c >= a && a != c && a == "apple" {
+		s++ // 24
+	}
+	if a >= "apple" && !(a > "apple") {
+		s++ // 25
+	}
+
+	var n, d uint8 = 200, 3
+	if n/d+n%d == 68 {
+		s++ // 26
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package main
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+type List struct {
+	Head *Node
+	Len  int
+}
+
+func Push(l *List, v int) {
+	l.Head = &Node{v, l.Head}
+	l.Len++
+}
+
+func Sum(l *List) int {
+	total := 0
+	for n := l.Head; n != nil; n = n.Next {
+		total += n.Value
+	}
+	return total
+}
+
+func Swap(a, b *int) {
+	*a, *b = *b, *a
+}
+
+func Counter() *int {
+	c := 40
+	return &c
+}
+
+var global int
+
+func main() int {
+	l := new(List)
+	for i := 1; i < 6; i++ {
+		Push(l, i)
+	}
+	x, y := 1, 2
+	Swap(&x, &y)
+	if x != 2 {
+		return 1
+	}
+	if y != 1 {
+		return 1
+	}
+	p := &global
+	*p = 7
+	var s List
+	Push(&s, 3)
+	(*l).Len += s.Len
+	c := Counter()
+	*c += 2
+	var q *Node
+	if q != nil {
+		return 2
+	}
+	if nil != q {
+		return 2
+	}
+	return Sum(l)*1000 + l.Len*100 + *c + global - 7
+}
@@ -0,0 +1,37 @@
+package main
+
+func Shadow(a int64) int64 {
+	x := a
+	if a > 0 {
+		x := 100
+		x++
+		a := x
+		a++
+	}
+	{
+		x := 7
+		x = x + 1
+	}
+	for x := 0; x < 3; x++ {
+		a = a + 1
+	}
+	if y := a * 2; y > 10 {
+		x = y
+	} else if z := y + 1; z > 0 {
+		x = z
+	}
+	return x
+}
+
+func Other(a int64) int64 {
+	return a
+}
+
+func main() int {
+	s := Shadow(3) + Shadow(-3) // 12+1 = 13
+	if Shadow(-20) == -20 {
+		s++ // 14
+	}
+	s += Other(4) // 18
+	return int(s)
+}
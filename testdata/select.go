@@ -0,0 +1,142 @@
+package main
+
+// named as the libc function the runtime seeds select with
+var time int = 1
+
+func fib(n int, out chan<- int, quit <-chan bool) {
+	a, b := 0, 1
+	for {
+		select {
+		case out <- a:
+			a, b = b, a+b
+		case <-quit:
+			close(out)
+			return
+		}
+	}
+}
+
+func worker(id int, jobs <-chan int, results chan<- int) {
+	for j := range jobs {
+		results <- j * id
+	}
+}
+
+func main() int {
+	// blocking on a send and a receive
+	out := make(chan int)
+	quit := make(chan bool)
+	go fib(100, out, quit)
+	s := 0
+	for i := 0; i < 8; i++ {
+		s += <-out // 0+1+1+2+3+5+8+13 = 33
+	}
+	quit <- true
+	_, ok := <-out
+	if ok == false {
+		s++ // 34
+	}
+
+	// non-blocking operations
+	c := make(chan int, 1)
+	select {
+	case x := <-c:
+		s += x
+	default:
+		s++ // 35
+	}
+	select {
+	case c <- 5:
+	default:
+		s += 100
+	}
+	select {
+	case c <- 6:
+		s += 100
+	default:
+		s++ // 36
+	}
+	select {
+	case x, ok := <-c:
+		if ok {
+			s += x // 41
+		}
+	default:
+	}
+
+	// receives from closed channels are ready, nil channels never are
+	var nilch chan int
+	done := make(chan bool)
+	close(done)
+	var closed bool
+	select {
+	case <-nilch:
+		s += 100
+	case nilch <- 1:
+		s += 100
+	case closed = <-done:
+		if closed == false {
+			s++ // 42
+		}
+	}
+
+	// waiting on several channels fed by goroutines
+	jobs := make(chan int)
+	results := make(chan int)
+	go worker(1, jobs, results)
+	go worker(2, jobs, results)
+	total := 0
+	sent := 0
+	received := 0
+loop:
+	for {
+		if sent == 4 {
+			if received == 4 {
+				break
+			}
+		}
+		if sent == 4 {
+			// no more jobs: a nil channel disables the case
+			jobs = nil
+		}
+		select {
+		case jobs <- sent + 1:
+			sent++
+		case r := <-results:
+			total += r
+			received++
+			if received == 4 {
+				if sent == 4 {
+					break loop
+				}
+			}
+		}
+	}
+	if total > 9 {
+		if total < 21 {
+			s++ // 43, each of 1..4 was doubled or not
+		}
+	}
+
+	// the cases are picked at random among the ready ones
+	a := make(chan int, 100)
+	b := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		a <- 1
+		b <- 2
+	}
+	na := 0
+	for i := 0; i < 100; i++ {
+		select {
+		case <-a:
+			na++
+		case <-b:
+		}
+	}
+	if na > 10 {
+		if na < 90 {
+			s += time // 44
+		}
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package main
+
+type Stack struct {
+	items []int
+}
+
+func Push(s *Stack, x int) {
+	s.items = append(s.items, x)
+}
+
+func Pop(s *Stack) int {
+	n := len(s.items)
+	x := s.items[n-1]
+	s.items = s.items[:n-1]
+	return x
+}
+
+func Sum(xs []int) int {
+	t := 0
+	for i := 0; i < len(xs); i++ {
+		t += xs[i]
+	}
+	return t
+}
+
+// named as the libc function the runtime allocates slices with
+func calloc(n, size int) int {
+	return n * size
+}
+
+func main() int {
+	var s Stack
+	for i := 0; i < 100; i++ {
+		Push(&s, i)
+	}
+	if Pop(&s) != 99 {
+		return 1
+	}
+	if len(s.items) != 99 {
+		return 2
+	}
+	var arr [5]int
+	part := arr[1:3]
+	part[0] = 7
+	if arr[1] != 7 {
+		return 3
+	}
+	if cap(part) != 4 {
+		return 4
+	}
+	full := arr[1:2:2]
+	full = append(full, 8)
+	if arr[2] != 0 {
+		return 5
+	}
+	m := make([]int, 3, 10)
+	n := copy(m, []int{1, 2, 3, 4})
+	m = append(m, m...)
+	var nilSlice []int
+	if nilSlice != nil {
+		return 6
+	}
+	if m == nil {
+		return 7
+	}
+	grid := [][]int{{1, 2}, {3}, 2: {4, 5, 6}}
+	words := []*Stack{{}, {items: []int{1}}}
+	return Sum(s.items)/100 + n*10 + Sum(m) + len(grid[2]) + Sum(grid[0]) + len(words[1].items) + cap(nilSlice) + calloc(0, 8)
+}
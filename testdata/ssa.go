@@ -0,0 +1,51 @@
+package main
+
+func Nested(a int64) int64 {
+	var x int64 = a * a
+	if a > 0 {
+		if a < 100 {
+			a = x + 10
+		} else {
+			a = a + 5
+			x = 12
+		}
+		a = a + 20
+	}
+	return a + x
+}
+
+func Unchanged(b int64) int64 {
+	var y int64 = b
+	var i int64
+	for i = 0; i < 10; i++ {
+		if i > 5 {
+			if i > 7 {
+				y = y + 0
+			}
+		}
+	}
+	return y
+}
+
+func LoopDiamond(n int64) int64 {
+	var odd int64
+	var even int64
+	for n > 0 {
+		if n%2 > 0 {
+			odd++
+		} else {
+			even++
+		}
+		n--
+	}
+	return odd*100 + even
+}
+
+func main() int {
+	s := Nested(3) + Nested(-2) // 48+2 = 50
+	s += Nested(200) - 230      // 57
+	s += Unchanged(9)           // 66
+	d := LoopDiamond(5)
+	s += d/100 + d%100 // 3 odd and 2 even, 71
+	return int(s)
+}
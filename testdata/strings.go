@@ -0,0 +1,67 @@
+package main
+
+const greeting = "hello"
+
+const n = len(greeting)
+
+var global = greeting + ", world"
+
+func Count(s string, b byte) int {
+	c := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == b {
+			c++
+		}
+	}
+	return c
+}
+
+func main() int {
+	s := global
+	if len(s) != 12 {
+		return 1
+	}
+	if s[:n] != greeting {
+		return 2
+	}
+	if s[7:] != "world" {
+		return 3
+	}
+	if "abc" > "abd" {
+		return 4
+	}
+	if "ab" > "abc" {
+		return 4
+	}
+	t := s[:0]
+	t += "x"
+	t = t + "y"
+	if t != "xy" {
+		return 5
+	}
+	b := []byte(s)
+	b[0] = 72
+	if s[0] != 104 {
+		return 6
+	}
+	if string(b[:5]) != "Hello" {
+		return 6
+	}
+	r := []rune("h€llo")
+	if len(r) != 5 {
+		return 7
+	}
+	if r[1] != 8364 {
+		return 7
+	}
+	r[0] = 72
+	u := string(r)
+	if u != "H€llo" {
+		return 8
+	}
+	var empty string
+	if empty != "" {
+		return 9
+	}
+	return Count(s, 111) + len(u) + len(b)
+}
@@ -0,0 +1,51 @@
+package main
+
+const Origin Coord = 0
+
+type Coord int
+
+type Point struct {
+	X, Y Coord
+}
+
+type Rect struct {
+	Min, Max Point
+	_        int
+}
+
+var unit = Rect{Max: Point{1, 1}}
+
+func Area(r Rect) Coord {
+	return (r.Max.X - r.Min.X) * (r.Max.Y - r.Min.Y)
+}
+
+func Grow(r Rect, d Coord) Rect {
+	r.Max.X += d
+	r.Max.Y = r.Max.Y + d
+	r.Min.X--
+	return r
+}
+
+func main() int {
+	type pair struct{ a, b int }
+	var p pair
+	p.a = 3
+	q := pair{b: 4}
+	q.a = p.a
+	if p == q {
+		return 1
+	}
+	p.b = 4
+	if p != q {
+		return 2
+	}
+	var r Rect
+	r.Max = Point{X: 2, Y: 3}
+	r.Min.X, r.Min.Y = r.Max.Y-2, Origin
+	r2 := r
+	r2.Max.Y = 10
+	if r.Max.Y != 3 {
+		return 3
+	}
+	return int(Area(Grow(r, 2))) + int(Area(unit))*100
+}
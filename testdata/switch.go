@@ -0,0 +1,148 @@
+package main
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+	Yellow
+	Black
+)
+
+// dense integer cases
+func weight(c Color) int {
+	switch c {
+	case Red:
+		return 1
+	case Green, Blue:
+		return 2
+	default:
+		return 10
+	case Yellow:
+		return 3
+	case Black:
+		return 4
+	}
+}
+
+// sparse cases are compared in order
+func bucket(x int) int {
+	switch x {
+	case 1000000:
+		return 1
+	case -5, 77:
+		return 2
+	}
+	return 0
+}
+
+func grade(score int) string {
+	switch {
+	case score > 89:
+		return "A"
+	case score > 79:
+		return "B"
+	case score == 0:
+		return "?"
+	}
+	return "C"
+}
+
+func name(s string) int {
+	switch s {
+	case "one":
+		return 1
+	case "two", "deux":
+		return 2
+	}
+	return -1
+}
+
+func falls(x int) int {
+	n := 0
+	switch x {
+	case 0:
+		n += 1
+		fallthrough
+	case 1:
+		n += 10
+		fallthrough
+	default:
+		n += 100
+	case 2:
+		n += 1000
+	}
+	return n
+}
+
+func counts(xs []int) int {
+	n := 0
+	for _, x := range xs {
+		switch y := x * 2; y {
+		case 2, 4, 6, 8:
+			if y == 4 {
+				break
+			}
+			n++
+		case 10:
+			continue
+		}
+		n += 10
+	}
+	return n
+}
+
+func first(xs []int) int {
+	i := 0
+loop:
+	for {
+		switch xs[i] {
+		case 7:
+			break loop
+		}
+		i++
+	}
+	return i
+}
+
+func main() int {
+	s := 0
+	for c := Red; c < Black+2; c++ {
+		s += weight(c) // 1+2+2+3+4+10 = 22
+	}
+	s += bucket(77) + bucket(1000000) + bucket(3) // 25
+	if grade(95) == "A" {
+		s++ // 26
+	}
+	if grade(85) == "B" {
+		s++ // 27
+	}
+	if grade(50) == "C" {
+		s++ // 28
+	}
+	s += name("deux") + name("one") + name("x") // 30
+	if falls(0) == 111 {
+		s++ // 31
+	}
+	if falls(1) == 110 {
+		s++ // 32
+	}
+	if falls(2) == 1000 {
+		s++ // 33
+	}
+	if falls(5) == 100 {
+		s++ // 34
+	}
+	s += counts([]int{1, 2, 3, 5, 9}) // 76
+	s += first([]int{1, 2, 7, 3})     // 78
+
+	var e interface{} = 3
+	switch e {
+	case "3":
+		s += 100
+	case 3:
+		s++ // 79
+	}
+	return s
+}
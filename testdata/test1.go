@@ -18,7 +18,6 @@ func TestCmp(a, b int64) bool {
 }
 
 
-/*
 func TestBranch(a, b int64) int64 {
 	var x int64 = a
 	if a > b {
@@ -26,7 +25,6 @@ func TestBranch(a, b int64) int64 {
 	}
 	return x
 }
-*/
 
 /*
 func Test(a, b int64, c int32) int64 {
@@ -45,7 +43,6 @@ func Test(a, b int64, c int32) int64 {
 }
 */
 
-/*
 func TestNested(a int64) int64 {
 	var x int64 = a * a
 	if a > 0 {
@@ -59,7 +56,6 @@ func TestNested(a int64) int64 {
 	}
 	return a + x
 }
-*/
 
 /*
 func Printf(a string) int64 {
@@ -76,21 +72,18 @@ func main() int64 {
 }
 */
 
-/*
 func TestScope(a int64) int64 {
 	if a:=1; a>0 {
 		a = 2
 	}
 	return a
 }
-*/
 
 //func main(a int32) {
 //Test(1,2,3)
 //	1+a
 //}
 
-/*
 
 // main func
 func main() {
@@ -101,4 +94,3 @@ func main() {
 
 	//fmt.Println(x, y)
 }
-*/